@@ -0,0 +1,224 @@
+// Package alert posts alerts to a Matrix room when a bridge goes down.
+package alert
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+// DefaultFailures is the default number of consecutive failed probes before an alert is sent.
+const DefaultFailures = 3
+
+// sendTimeout is the maximum duration of sending an alert.
+const sendTimeout = 30 * time.Second
+
+// queueSize is the maximum number of alerts waiting to be sent.
+const queueSize = 100
+
+// Kind is the kind of alert.
+type Kind string
+
+const (
+	// KindDown indicates that a number of consecutive probes failed.
+	KindDown Kind = "down"
+
+	// KindSlow indicates that the round trip time was above the threshold for too long.
+	KindSlow Kind = "slow"
+)
+
+// Config is the configuration for an Alerter.
+type Config struct {
+	// Room is the room alerts are posted in.
+	Room id.RoomID
+
+	// Failures is the number of consecutive failed probes after which an alert is sent.
+	// Defaults to DefaultFailures.
+	Failures int
+
+	// RTT is the round trip time above which a network is considered slow.
+	// Slow networks are not alerted on if this is zero.
+	RTT time.Duration
+
+	// For is the duration the round trip time has to stay above the threshold
+	// before an alert is sent.
+	For time.Duration
+}
+
+// Alerter tracks the results of probes, and posts alerts and recoveries to a Matrix room.
+// Every incident is only alerted once, and alerts can be silenced per network.
+// It implements [matrix.Silencer].
+type Alerter struct {
+	client *matrix.Client
+	config Config
+
+	mu       sync.Mutex
+	networks map[string]*state
+	silences map[string]time.Time
+
+	// queue contains the alerts to send, in order
+	queue chan *alert
+}
+
+// state contains the alert state of a single network.
+// An incident is firing as soon as it is detected,
+// but is only notified once the alert has been posted.
+type state struct {
+	failures  int
+	slowSince time.Time
+	firing    Kind
+	notified  Kind
+	since     time.Time
+}
+
+// New returns an Alerter that posts to the configured room.
+// Alerts are posted in the background, in the order they occur.
+// Alerts can be silenced using the admin commands of the client.
+func New(client *matrix.Client, config *Config) *Alerter {
+	a := &Alerter{
+		client:   client,
+		config:   *config,
+		networks: make(map[string]*state),
+		silences: make(map[string]time.Time),
+		queue:    make(chan *alert, queueSize),
+	}
+
+	if a.config.Failures <= 0 {
+		a.config.Failures = DefaultFailures
+	}
+
+	go a.run()
+
+	return a
+}
+
+// Observe adds the result of a probe.
+// An alert is posted when the network goes down or becomes slow,
+// or when the silence of an ongoing incident has ended.
+// A recovery is posted when the network is healthy again, if the alert was posted.
+// Observe does not block on sending the alert.
+func (a *Alerter) Observe(r *ping.Result) {
+	msg := a.update(r)
+	if msg == nil {
+		return
+	}
+
+	select {
+	case a.queue <- msg:
+	default:
+		slog.Error("Alert queue is full, dropping alert", "network", msg.Network, "kind", msg.Kind, "resolved", msg.Resolved)
+	}
+}
+
+// run sends the queued alerts.
+func (a *Alerter) run() {
+	for msg := range a.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		if err := a.send(ctx, msg); err != nil {
+			slog.Error("Error sending alert", "network", msg.Network, "kind", msg.Kind, "err", err)
+		}
+		cancel()
+	}
+}
+
+// update updates the state of a network with a probe result,
+// and returns the alert to send, if any.
+func (a *Alerter) update(r *ping.Result) *alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.networks[r.Network]
+	if !ok {
+		s = new(state)
+		a.networks[r.Network] = s
+	}
+
+	var rtt time.Duration
+	if r.Success() {
+		rtt = r.Delay.RTT()
+		s.failures = 0
+		switch {
+		case a.config.RTT <= 0 || rtt <= a.config.RTT:
+			s.slowSince = time.Time{}
+		case s.slowSince.IsZero():
+			s.slowSince = r.Time
+		}
+	} else {
+		s.failures++
+	}
+
+	// Failed probes below the failure threshold never resolve an incident,
+	// only a successful probe below the round trip time threshold does.
+	var kind Kind
+	switch {
+	case s.failures >= a.config.Failures:
+		kind = KindDown
+		s.slowSince = time.Time{}
+	case !r.Success():
+		kind = s.firing
+	case !s.slowSince.IsZero() && r.Time.Sub(s.slowSince) >= a.config.For:
+		kind = KindSlow
+	}
+
+	// Track the start of the incident
+	since := s.since
+	if s.firing == "" && kind != "" {
+		s.since = r.Time
+		if kind == KindSlow {
+			s.since = s.slowSince
+		}
+	}
+	s.firing = kind
+
+	// Deduplicate alerts for the same incident
+	if kind == s.notified {
+		return nil
+	}
+
+	msg := &alert{
+		Network:   r.Network,
+		Kind:      kind,
+		Failures:  s.failures,
+		Reason:    r.Reason,
+		RTT:       rtt,
+		Threshold: a.config.RTT,
+	}
+
+	switch {
+	case kind == "":
+		// Recoveries are never silenced, as the alert has been posted
+		msg.Kind = s.notified
+		msg.Resolved = true
+		msg.Duration = r.Time.Sub(since)
+	case a.silenced(r.Network, r.Time):
+		slog.Info("Alert silenced", "network", r.Network, "kind", kind)
+		return nil
+	case kind == KindSlow:
+		msg.Duration = r.Time.Sub(s.slowSince)
+	}
+
+	s.notified = kind
+
+	return msg
+}
+
+// send posts an alert to the configured room.
+func (a *Alerter) send(ctx context.Context, msg *alert) error {
+	var plain, formatted strings.Builder
+	if err := textTemplate.Execute(&plain, msg); err != nil {
+		return err
+	}
+	if err := htmlTemplate.Execute(&formatted, msg); err != nil {
+		return err
+	}
+
+	_, err := a.client.SendHTML(ctx, a.config.Room, plain.String(), formatted.String())
+	return err
+}
@@ -0,0 +1,116 @@
+package alert
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+func TestAlerterUpdate(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+	config := Config{Failures: 3, RTT: time.Second, For: 2 * time.Minute}
+	fast, slow := 100*time.Millisecond, 2*time.Second
+
+	// A zero round trip time is a failed probe, and an empty alert is no alert
+	tests := []struct {
+		name    string
+		config  Config
+		silence string
+		until   int
+		rtts    []time.Duration
+		want    []string
+	}{
+		{
+			name:   "down",
+			config: config,
+			rtts:   []time.Duration{0, 0, 0, 0, fast},
+			want:   []string{"", "", "ALERT down 0s", "", "RESOLVED down 2m0s"},
+		},
+		{
+			name:   "failures below threshold",
+			config: config,
+			rtts:   []time.Duration{0, 0, fast, 0, 0, fast},
+			want:   []string{"", "", "", "", "", ""},
+		},
+		{
+			name:   "slow",
+			config: config,
+			rtts:   []time.Duration{slow, slow, slow, slow, fast},
+			want:   []string{"", "", "ALERT slow 2m0s", "", "RESOLVED slow 4m0s"},
+		},
+		{
+			name:   "slow disabled",
+			config: Config{Failures: 3},
+			rtts:   []time.Duration{slow, slow, slow, slow},
+			want:   []string{"", "", "", ""},
+		},
+		{
+			name:   "failure does not resolve slow",
+			config: config,
+			rtts:   []time.Duration{slow, slow, slow, 0, slow, fast},
+			want:   []string{"", "", "ALERT slow 2m0s", "", "", "RESOLVED slow 5m0s"},
+		},
+		{
+			name:   "slow then down",
+			config: config,
+			rtts:   []time.Duration{slow, slow, slow, 0, 0, 0, fast},
+			want:   []string{"", "", "ALERT slow 2m0s", "", "", "ALERT down 0s", "RESOLVED down 6m0s"},
+		},
+		{
+			name:    "alerted after silence",
+			config:  config,
+			silence: "test",
+			until:   3,
+			rtts:    []time.Duration{0, 0, 0, 0, 0, fast},
+			want:    []string{"", "", "", "ALERT down 0s", "", "RESOLVED down 3m0s"},
+		},
+		{
+			name:    "resolved while silenced",
+			config:  config,
+			silence: AllNetworks,
+			until:   10,
+			rtts:    []time.Duration{0, 0, 0, 0, fast},
+			want:    []string{"", "", "", "", ""},
+		},
+		{
+			name:    "other network silenced",
+			config:  config,
+			silence: "other",
+			until:   10,
+			rtts:    []time.Duration{0, 0, 0, fast},
+			want:    []string{"", "", "ALERT down 0s", "RESOLVED down 1m0s"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New(nil, &test.config)
+			if test.silence != "" {
+				a.silences[test.silence] = at(test.until)
+			}
+
+			for i, rtt := range test.rtts {
+				r := &ping.Result{Network: "test", Time: at(i), Reason: ping.ReasonPongLost}
+				if rtt > 0 {
+					r.Reason = ""
+					r.Delay = &ping.Delay{Ping: &ping.Message{Sent: at(i)}, Pong: &ping.Message{Received: at(i).Add(rtt)}}
+				}
+
+				var got string
+				if msg := a.update(r); msg != nil {
+					got = fmt.Sprintf("ALERT %s %s", msg.Kind, msg.Duration)
+					if msg.Resolved {
+						got = fmt.Sprintf("RESOLVED %s %s", msg.Kind, msg.Duration)
+					}
+				}
+
+				if got != test.want[i] {
+					t.Errorf("Probe %d: expected %q, got %q", i, test.want[i], got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package alert
+
+import (
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+)
+
+// AllNetworks is the network name used to silence all networks.
+const AllNetworks = matrix.AllNetworks
+
+// Silence silences the alerts of a network, or all networks, for a duration.
+// It returns the time the silence ends.
+func (a *Alerter) Silence(network string, d time.Duration) time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	until := time.Now().Add(d)
+	a.silences[network] = until
+	return until
+}
+
+// Unsilence removes the silence of a network.
+// It returns false if the network was not silenced.
+func (a *Alerter) Unsilence(network string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, ok := a.silences[network]
+	delete(a.silences, network)
+	return ok
+}
+
+// Silences returns the end times of the active silences, by network.
+// Expired silences are removed.
+func (a *Alerter) Silences() map[string]time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	silences := make(map[string]time.Time, len(a.silences))
+	for n, until := range a.silences {
+		if now.After(until) {
+			delete(a.silences, n)
+			continue
+		}
+		silences[n] = until
+	}
+
+	return silences
+}
+
+// silenced returns true if alerts for a network are silenced at the given time.
+// The caller must hold a.mu.
+func (a *Alerter) silenced(network string, t time.Time) bool {
+	for _, n := range []string{network, AllNetworks} {
+		if until, ok := a.silences[n]; ok && t.Before(until) {
+			return true
+		}
+	}
+
+	return false
+}
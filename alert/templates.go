@@ -0,0 +1,56 @@
+package alert
+
+import (
+	htmltemplate "html/template"
+	"text/template"
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+const (
+	alertText = `{{if .Resolved}}RESOLVED{{else}}ALERT{{end}} {{.Network}}: ` +
+		`{{if .Resolved}}bridge is healthy again after {{formatDuration .Duration}}` +
+		`{{else if eq .Kind "down"}}bridge is down, {{.Failures}} consecutive probes failed ({{.Reason}})` +
+		`{{else}}round trip time {{formatDuration .RTT}} above {{formatDuration .Threshold}} for {{formatDuration .Duration}}{{end}}`
+	alertHTML = `<strong>{{if .Resolved}}✅ RESOLVED{{else}}🚨 ALERT{{end}}</strong> <code>{{.Network}}</code>: ` +
+		`{{if .Resolved}}bridge is healthy again after {{formatDuration .Duration}}` +
+		`{{else if eq .Kind "down"}}bridge is down, <strong>{{.Failures}}</strong> consecutive probes failed (<code>{{.Reason}}</code>)` +
+		`{{else}}round trip time <strong>{{formatDuration .RTT}}</strong> above {{formatDuration .Threshold}} for {{formatDuration .Duration}}{{end}}`
+)
+
+var (
+	textTemplate = template.Must(template.New("text").
+			Funcs(template.FuncMap{"formatDuration": formatDuration}).Parse(alertText))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("html").
+			Funcs(htmltemplate.FuncMap{"formatDuration": formatDuration}).Parse(alertHTML))
+)
+
+// alert contains the data of an alert or recovery message.
+type alert struct {
+	Network  string
+	Kind     Kind
+	Resolved bool
+
+	// Failures is the number of consecutive failed probes, and Reason the reason of the last failure.
+	Failures int
+	Reason   ping.Reason
+
+	// RTT is the round trip time of the last probe, and Threshold the configured threshold.
+	RTT, Threshold time.Duration
+
+	// Duration is the duration of the incident.
+	Duration time.Duration
+}
+
+// formatDuration formats a duration for use in a message.
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return d.Round(time.Millisecond).String()
+	case d < time.Minute:
+		return d.Round(100 * time.Millisecond).String()
+	default:
+		return d.Round(time.Second).String()
+	}
+}
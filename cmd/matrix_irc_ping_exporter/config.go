@@ -1,17 +1,33 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log/slog"
+	"strings"
 
+	"github.com/silkeh/matrix_irc_ping_exporter/alert"
 	"github.com/silkeh/matrix_irc_ping_exporter/irc"
 	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+	"github.com/silkeh/matrix_irc_ping_exporter/prometheus"
 	"gopkg.in/yaml.v3"
+	"maunium.net/go/mautrix/id"
 )
 
 // Config is used for the main configuration
 type Config struct {
 	IRC    map[string]*irc.Config
 	Matrix *matrix.Config
+	Probes map[string]*prometheus.Probe
+
+	// Alert configures alerts in a Matrix room.
+	// Alerts are disabled if this is not set.
+	Alert *alert.Config
+
+	// Secret is used to sign and verify ping messages,
+	// unless a secret is configured for Matrix or IRC.
+	Secret string
 }
 
 // loadConfig loads configuration
@@ -34,6 +50,85 @@ func loadConfig(path string) (config *Config, err error) {
 	if config.Matrix.MessageType == "" {
 		config.Matrix.MessageType = "m.notice"
 	}
+	if config.Matrix.Secret == "" {
+		config.Matrix.Secret = config.Secret
+	}
+	for _, c := range config.IRC {
+		if c.Secret == "" {
+			c.Secret = config.Secret
+		}
+	}
+
+	return config, config.validate()
+}
+
+// validate checks the probe definitions against the Matrix and IRC configuration,
+// and the alert configuration.
+// The responder of a probe defaults to the nick of the linked IRC client,
+// if the Matrix user ID of the bridged IRC users is configured.
+// The bridged IRC clients that send reverse probes are added to the probe users.
+func (c *Config) validate() (err error) {
+	for n, p := range c.Probes {
+		if _, ok := c.Matrix.Rooms[n]; !ok {
+			err = errors.Join(err, fmt.Errorf("probe %q: no Matrix room configured", n))
+		}
+
+		if p.IRC != "" {
+			conf, ok := c.IRC[p.IRC]
+			switch {
+			case !ok:
+				err = errors.Join(err, fmt.Errorf("probe %q: unknown IRC network %q", n, p.IRC))
+			case p.Channel == "":
+				err = errors.Join(err, fmt.Errorf("probe %q: no IRC channel configured", n))
+			case !containsFold(conf.Channels, p.Channel) && !strings.EqualFold(conf.ProbeChannel, p.Channel):
+				err = errors.Join(err, fmt.Errorf("probe %q: channel %q is not joined on IRC network %q", n, p.Channel, p.IRC))
+			}
+
+			if ok && p.Responder == "" && p.Puppet != "" {
+				p.Responder = conf.Nick
+			}
+			if ok && conf.ProbeChannel != "" && p.Puppet != "" {
+				c.Matrix.ProbeUsers = append(c.Matrix.ProbeUsers, id.UserID(p.PuppetID(conf.Nick)))
+			}
+		}
+
+		switch {
+		case p.Puppet != "" && (!strings.HasPrefix(p.Puppet, "@") || !strings.Contains(p.Puppet, "{nick}")):
+			err = errors.Join(err, fmt.Errorf("probe %q: puppet %q is not a Matrix user ID containing {nick}", n, p.Puppet))
+		case p.Responder != "" && !strings.HasPrefix(p.Responder, "@") && p.Puppet == "":
+			err = errors.Join(err, fmt.Errorf("probe %q: responder %q is an IRC nick, but no puppet is configured", n, p.Responder))
+		}
+
+		if p.Responder == "" {
+			slog.Warn("No responder configured, accepting replies from any user", "probe", n)
+		}
+	}
+
+	for n, conf := range c.IRC {
+		if conf.ProbeChannel != "" && conf.MatrixNick == "" {
+			err = errors.Join(err, fmt.Errorf("irc %q: matrixnick is required for the probe channel", n))
+		}
+	}
+
+	if c.Alert != nil && !strings.HasPrefix(string(c.Alert.Room), "!") {
+		err = errors.Join(err, fmt.Errorf("alert: room %q is not a room ID", c.Alert.Room))
+	}
+
+	for n := range c.Matrix.Rooms {
+		if _, ok := c.Probes[n]; !ok && len(c.Probes) > 0 {
+			slog.Warn("No probe configured for room, accepting replies from any user", "room", n)
+		}
+	}
 
 	return
 }
+
+// containsFold returns true if the list contains the given string, ignoring case.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,33 +1,55 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log/slog"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/silkeh/matrix_irc_ping_exporter/alert"
+	"github.com/silkeh/matrix_irc_ping_exporter/history"
 	"github.com/silkeh/matrix_irc_ping_exporter/internal/log"
 	"github.com/silkeh/matrix_irc_ping_exporter/irc"
 	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
 	"github.com/silkeh/matrix_irc_ping_exporter/prometheus"
 )
 
 var ircClients = make(map[string]*irc.Client)
 
 func main() {
-	var addr, configFile, logLevel string
-	var pingTimeout time.Duration
+	var addr, configFile, logLevel, bucketList, instance, historyFile string
+	var pingTimeout, probeInterval, retention time.Duration
+	var concurrency, window int
+	var correctClockOffset bool
 
 	flag.StringVar(&addr, "addr", ":9200", "Listen address")
 	flag.StringVar(&configFile, "config", "config.yaml", "Configuration file")
 	flag.StringVar(&logLevel, "loglevel", "info", "Log level")
 	flag.DurationVar(&pingTimeout, "timeout", 60*time.Second, "Ping timeout")
+	flag.DurationVar(&probeInterval, "interval", 60*time.Second, "Probe interval, 0 disables background probes")
+	flag.IntVar(&concurrency, "concurrency", prometheus.DefaultConcurrency, "Maximum number of pings sent at the same time")
+	flag.BoolVar(&correctClockOffset, "correct-clock-offset", false, "Correct delays for the estimated clock offset of IRC responders")
+	flag.StringVar(&instance, "instance", "", "Name of this exporter used in ping IDs, random by default")
+	flag.StringVar(&bucketList, "buckets", "", "Comma separated list of delay histogram buckets in seconds")
+	flag.IntVar(&window, "window", ping.DefaultWindow, "Number of probes used for the rolling statistics")
+	flag.StringVar(&historyFile, "history", "", "Database file for the probe history, empty disables the history")
+	flag.DurationVar(&retention, "history-retention", history.DefaultRetention, "Duration the probe history is kept")
 	flag.Parse()
 
 	if err := log.Setup(logLevel); err != nil {
 		log.Fatal("Invalid loglevel", "level", logLevel, "err", err)
 	}
 
+	buckets, err := parseBuckets(bucketList)
+	if err != nil {
+		log.Fatal("Invalid histogram buckets", "buckets", bucketList, "err", err)
+	}
+
 	slog.Info("Starting...")
 
 	// Load configuration
@@ -52,11 +74,90 @@ func main() {
 		go ircClients[n].Loop()
 	}
 
-	// Create exporter
-	exporter := prometheus.NewExporter(client, config.Matrix.Rooms, pingTimeout)
+	// Open the probe history
+	var store *history.Store
+	if historyFile != "" {
+		store, err = history.Open(historyFile, retention)
+		if err != nil {
+			log.Fatal("Error opening history", "path", historyFile, "err", err)
+		}
+		defer store.Close()
+	}
+
+	// Create alerter
+	var alerter *alert.Alerter
+	var onResult func(r *ping.Result)
+	if config.Alert != nil {
+		_, err = client.JoinRoom(context.Background(), string(config.Alert.Room), "", nil)
+		if err != nil {
+			log.Fatal("Error joining alert room", "room_id", config.Alert.Room, "err", err)
+		}
+		alerter = alert.New(client, config.Alert)
+		onResult = alerter.Observe
+	}
+
+	// Create and start exporter
+	exporter, err := prometheus.NewExporter(client, &prometheus.Config{
+		Rooms:              config.Matrix.Rooms,
+		IRC:                ircClients,
+		Probes:             config.Probes,
+		Timeout:            pingTimeout,
+		Interval:           probeInterval,
+		Concurrency:        concurrency,
+		Buckets:            buckets,
+		Instance:           instance,
+		CorrectClockOffset: correctClockOffset,
+		Window:             window,
+		History:            store,
+		OnResult:           onResult,
+	})
+	if err != nil {
+		log.Fatal("Error creating exporter", "err", err)
+	}
+
+	slog.Info("Created exporter", "instance", exporter.Instance())
+
+	// Enable admin commands, and the silence commands if alerts are enabled
+	if len(config.Matrix.Admins) > 0 {
+		client.EnableAdmin(exporter)
+	}
+	if alerter != nil {
+		client.EnableSilence(alerter, config.Alert.Room)
+	}
+	if probeInterval > 0 {
+		go exporter.Run()
+	}
 
 	// Create HTTP server
 	slog.Info("Listening", "addr", addr)
+	http.HandleFunc("/", exporter.StatusHandler)
 	http.HandleFunc("/metrics", exporter.MetricsHandler)
+	http.HandleFunc("/probe", exporter.ProbeHandler)
+	http.HandleFunc("/events", exporter.EventsHandler)
+	http.HandleFunc("/api/v1/networks", exporter.APIHandler)
+	http.HandleFunc("/api/v1/networks/", exporter.APIHandler)
+	if store != nil {
+		http.HandleFunc("/history", store.QueryHandler)
+	}
 	log.Fatal("Listen error", "err", http.ListenAndServe(addr, nil))
 }
+
+// parseBuckets parses a comma separated list of histogram buckets.
+// The returned buckets are sorted.
+func parseBuckets(s string) (buckets []float64, err error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, v := range strings.Split(s, ",") {
+		b, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, b)
+	}
+
+	sort.Float64s(buckets)
+
+	return
+}
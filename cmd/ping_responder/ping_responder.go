@@ -18,6 +18,7 @@ func main() {
 	flag.StringVar(&channelList, "channels", "", "Comma separated list of channels to join")
 	flag.StringVar(&logLevel, "loglevel", "info", "Log level")
 	flag.BoolVar(&config.SSL, "ssl", false, "Use SSL for this connection")
+	flag.StringVar(&config.Secret, "secret", "", "Shared secret for signing ping messages")
 	flag.Parse()
 
 	if err := log.Setup(logLevel); err != nil {
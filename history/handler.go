@@ -0,0 +1,68 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultRange is the time range queried if no start time is given.
+const defaultRange = time.Hour
+
+// QueryHandler is an HTTP handler that returns stored results as JSON.
+// The results can be filtered using the `network`, `from` and `to` parameters.
+// Times are given in RFC 3339 format, or as Unix times in seconds.
+// By default, the results of the last hour are returned.
+func (s *Store) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	to, err := parseTime(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid end time: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, err := parseTime(q.Get("from"), to.Add(-defaultRange))
+	if err != nil {
+		http.Error(w, "invalid start time: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	network := q.Get("network")
+
+	slog.Debug("Handling history request", "network", network, "from", from, "to", to)
+
+	results, err := s.Query(network, from, to)
+	if err != nil {
+		slog.Error("Error querying history", "network", network, "err", err)
+		http.Error(w, "error querying history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		slog.Error("Error writing history", "err", err)
+	}
+}
+
+// parseTime parses a time in RFC 3339 format, or a Unix time in seconds.
+// The default is returned if the value is empty.
+func parseTime(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	if s, err := strconv.ParseFloat(v, 64); err == nil {
+		return time.Unix(0, int64(s*1e9)), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a valid time", v)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,121 @@
+// Package history stores the results of probes in an embedded database.
+package history
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+// DefaultRetention is the default duration results are kept.
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Store is a persistent store of probe results.
+// Results are stored in a bucket per network, ordered by probe time.
+type Store struct {
+	db *bolt.DB
+
+	// Retention is the duration results are kept.
+	Retention time.Duration
+}
+
+// Open opens or creates a store at the given path.
+// DefaultRetention is used if the retention is not positive.
+func Open(path string, retention time.Duration) (*Store, error) {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history: %w", err)
+	}
+
+	return &Store{db: db, Retention: retention}, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add stores the result of a probe, and removes results older than the retention.
+func (s *Store) Add(r *ping.Result) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(r.Network))
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(key(r.Time, r.ID), v); err != nil {
+			return err
+		}
+
+		return prune(b, time.Now().Add(-s.Retention))
+	})
+}
+
+// Query returns the results of a network with a probe time in the given range, ordered by time.
+// The results of all networks are returned if the network is empty.
+func (s *Store) Query(network string, from, to time.Time) (results []*ping.Result, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if network != "" && string(name) != network {
+				return nil
+			}
+
+			c := b.Cursor()
+			end := key(to, "")
+			for k, v := c.Seek(key(from, "")); k != nil && bytes.Compare(k[:8], end) <= 0; k, v = c.Next() {
+				r := new(ping.Result)
+				if err := json.Unmarshal(v, r); err != nil {
+					slog.Warn("Ignoring invalid history entry", "network", string(name), "err", err)
+					continue
+				}
+
+				results = append(results, r)
+			}
+
+			return nil
+		})
+	})
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Time.Before(results[j].Time)
+	})
+
+	return
+}
+
+// prune removes all results in a bucket with a probe time before the given time.
+func prune(b *bolt.Bucket, before time.Time) error {
+	c := b.Cursor()
+	end := key(before, "")
+	for k, _ := c.First(); k != nil && bytes.Compare(k[:8], end) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// key returns the key of a result: the big endian probe time followed by the probe ID.
+func key(t time.Time, id string) []byte {
+	k := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	return append(k, id...)
+}
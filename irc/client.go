@@ -3,26 +3,45 @@ package irc
 import (
 	"fmt"
 	"log/slog"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	irc "github.com/thoj/go-ircevent"
 	irclib "gopkg.in/sorcix/irc.v2"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
 )
 
 const (
 	// PingMessage contains the prefix for a ping message
-	PingMessage = "ping"
+	PingMessage = ping.PingMessage
 
 	// PingResponse contains the expected response prefix to a ping message
-	PingResponse = "pong"
+	PingResponse = ping.PingResponse
 )
 
+// serverTimeCap is the IRCv3 capability for server timestamps on messages.
+const serverTimeCap = "server-time"
+
 // Client is a simple IRC pong client
 type Client struct {
 	*irc.Connection
 	Channels []string
+
+	// ProbeChannel is the channel used for reverse probes.
+	ProbeChannel string
+
+	// Pongs receives the ping replies for reverse probes.
+	// It is nil if reverse probes are disabled.
+	Pongs chan *ping.Message
+
+	matrixNick string
+	signer     *ping.Signer
+	version    int
+
+	callbacksMu sync.RWMutex
+	onRejected  func(channel, reason string)
 }
 
 // Config is the configuration for a Client.
@@ -32,13 +51,34 @@ type Config struct {
 	Name     string
 	SSL      bool
 	Channels []string
+
+	// ProbeChannel is the channel used for reverse probes.
+	// Reverse probes are disabled if this is empty.
+	ProbeChannel string
+
+	// MatrixNick is the nick of the bridged Matrix bot.
+	// Only ping replies from this nick are used for reverse probes,
+	// so it is required if ProbeChannel is set.
+	MatrixNick string
+
+	// Secret is used to sign and verify ping messages.
+	// Ping messages are not signed if this is empty.
+	Secret string
+
+	// Version is the version of the wire format used for pings.
+	// Defaults to ping.Version.
+	Version int
 }
 
 // NewClient creates and connects a simple IRC pong client
 func NewClient(config *Config) (c *Client, err error) {
 	c = &Client{
-		Channels:   config.Channels,
-		Connection: irc.IRC(config.Nick, config.Name),
+		Channels:     config.Channels,
+		ProbeChannel: config.ProbeChannel,
+		Connection:   irc.IRC(config.Nick, config.Name),
+		matrixNick:   config.MatrixNick,
+		signer:       ping.NewSigner(config.Secret),
+		version:      config.Version,
 	}
 
 	// Catch invalid config
@@ -46,6 +86,15 @@ func NewClient(config *Config) (c *Client, err error) {
 		return nil, fmt.Errorf("invalid IRC name or realname: %q, %q", config.Nick, config.Name)
 	}
 
+	if c.version == 0 {
+		c.version = ping.Version
+	}
+
+	// Enable reverse probes
+	if c.ProbeChannel != "" {
+		c.Pongs = make(chan *ping.Message, 25)
+	}
+
 	// Configure the client
 	c.UseTLS = config.SSL
 
@@ -63,17 +112,33 @@ func NewClient(config *Config) (c *Client, err error) {
 	return
 }
 
+// SendPing sends a ping message to the probe channel.
+func (c *Client) SendPing(pingID string, ts time.Time) {
+	slog.Debug("Sending ping", "ping_id", pingID, "channel", c.ProbeChannel)
+
+	p := &ping.Packet{Kind: PingMessage, Version: c.version, ID: pingID, Time: ts, Source: c.GetNick()}
+	c.Privmsg(c.ProbeChannel, c.signer.Sign(p.String()))
+}
+
 // onConnect handles what should happen after a connection has been established
 func (c *Client) onConnect(e *irc.Event) {
 	slog.Info("Connected", "server", c.Server)
 
+	// Request server timestamps on messages
+	c.SendRaw("CAP REQ :" + serverTimeCap)
+
 	for _, ch := range c.Channels {
 		c.Join(ch)
 	}
+	if c.ProbeChannel != "" && !contains(c.Channels, c.ProbeChannel) {
+		c.Join(c.ProbeChannel)
+	}
 }
 
 // onPrivMsg handles incoming messages
 func (c *Client) onPrivMsg(e *irc.Event) {
+	now := time.Now()
+
 	if len(e.Arguments) != 2 {
 		return
 	}
@@ -84,42 +149,123 @@ func (c *Client) onPrivMsg(e *irc.Event) {
 	}
 
 	msg := strings.TrimSpace(e.Message())
-	if strings.HasPrefix(msg, PingMessage) {
-		slog.Info("Received ping message", "channel", channel, "msg", msg)
+	if !ping.IsPacket(msg) {
+		return
+	}
 
-		resp := createResponse(msg)
-		slog.Info("Sending ping reply", "channel", channel, "response", resp)
+	if ping.IsResponse(msg) {
+		c.onPong(e, channel, msg, now)
+		return
+	}
 
-		switch e.Code {
-		case irclib.NOTICE:
-			c.Notice(channel, resp)
-		default:
-			c.Privmsg(channel, resp)
-		}
+	slog.Info("Received ping message", "channel", channel, "msg", msg)
+
+	msg, err := c.signer.Verify(msg)
+	if err != nil {
+		c.reject(channel, msg, err)
+		return
+	}
+
+	p, err := ping.Parse(msg)
+	if err != nil {
+		slog.Info("Ignoring invalid ping message", "channel", channel, "msg", msg, "err", err)
+		return
+	}
+
+	reply := p.Reply(now, time.Now(), c.GetNick())
+	reply.PingIRC = serverTime(e)
+
+	resp := c.signer.Sign(reply.String())
+	slog.Info("Sending ping reply", "channel", channel, "response", resp)
+
+	switch e.Code {
+	case irclib.NOTICE:
+		c.Notice(channel, resp)
+	default:
+		c.Privmsg(channel, resp)
 	}
 }
 
-// createResponse creates the correct response for an incoming ping message.
-func createResponse(msg string) string {
-	now := time.Now()
-	id := "unixnano"
-	suffix := ""
-	parts := strings.Split(msg, " ")
-
-	// Check if a ping ID was given, if so: use it.
-	if len(parts) >= 2 {
-		id = parts[1]
-	}
-
-	// Check if a timestamp was given, and calculate the difference.
-	// This difference is appended to the return message.
-	if len(parts) >= 3 {
-		ts, err := strconv.ParseInt(parts[2], 0, 64)
-		if err == nil {
-			diff := now.Sub(time.Unix(0, ts))
-			suffix = fmt.Sprintf("%d %s", diff, diff)
-		}
+// onPong handles incoming ping replies for reverse probes.
+func (c *Client) onPong(e *irc.Event, channel, msg string, received time.Time) {
+	if c.Pongs == nil || channel != c.ProbeChannel {
+		return
+	}
+
+	if !strings.EqualFold(e.Nick, c.matrixNick) {
+		slog.Debug("Ignoring pong", "nick", e.Nick, "channel", channel)
+		return
+	}
+
+	msg, err := c.signer.Verify(msg)
+	if err != nil {
+		c.reject(channel, msg, err)
+		return
+	}
+
+	p, err := ping.Parse(msg)
+	if err != nil {
+		slog.Info("Received invalid pong", "channel", channel, "msg", msg, "err", err)
+		return
+	}
+
+	pong := p.Message(channel)
+	pong.Received = received
+	pong.Sender = e.Nick
+	pong.IRC = serverTime(e)
+
+	select {
+	case c.Pongs <- pong:
+	default:
+		slog.Warn("Dropping message", "kind", pong.Kind, "ping_id", pong.ID, "channel", channel)
 	}
+}
+
+// serverTime returns the time an event was stamped by the IRC server.
+// It returns the zero time if the event has no valid server time tag.
+func serverTime(e *irc.Event) time.Time {
+	v, ok := e.Tags["time"]
+	if !ok {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		slog.Debug("Invalid server time", "time", v, "err", err)
+		return time.Time{}
+	}
+
+	return t
+}
+
+// reject reports a message with an invalid signature, or a replayed message.
+func (c *Client) reject(channel, msg string, err error) {
+	slog.Warn("Rejecting message", "channel", channel, "msg", msg, "err", err)
 
-	return fmt.Sprintf("%s %s %v %s", PingResponse, id, now.UnixNano(), suffix)
+	c.callbacksMu.RLock()
+	f := c.onRejected
+	c.callbacksMu.RUnlock()
+
+	if f != nil {
+		f(channel, ping.RejectReason(err))
+	}
+}
+
+// HandleRejected registers a function that is called with the channel and reason
+// when a message with an invalid signature, or a replayed message is received.
+func (c *Client) HandleRejected(f func(channel, reason string)) {
+	c.callbacksMu.Lock()
+	defer c.callbacksMu.Unlock()
+
+	c.onRejected = f
+}
+
+// contains returns true if a list of channels contains the given channel.
+func contains(channels []string, channel string) bool {
+	for _, ch := range channels {
+		if strings.EqualFold(ch, channel) {
+			return true
+		}
+	}
+	return false
 }
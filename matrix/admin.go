@@ -0,0 +1,302 @@
+package matrix
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+const (
+	// StatusCommand shows the latest result of every network.
+	StatusCommand = "!status"
+
+	// ProbeCommand probes a network: `!probe <network>`.
+	ProbeCommand = "!probe"
+
+	// SilenceCommand silences the alerts of a network: `!silence <network|all> [duration]`.
+	// Without arguments, it lists the active silences.
+	SilenceCommand = "!silence"
+
+	// UnsilenceCommand removes the silence of a network: `!unsilence <network|all>`.
+	UnsilenceCommand = "!unsilence"
+
+	// RoomsCommand lists the configured rooms.
+	RoomsCommand = "!rooms"
+
+	// AllNetworks is the network name used to silence all networks.
+	AllNetworks = "all"
+
+	// DefaultSilence is the default duration of a silence.
+	DefaultSilence = time.Hour
+
+	// adminProbeTimeout is the maximum duration of a probe started by a command.
+	adminProbeTimeout = 2 * time.Minute
+)
+
+const (
+	statusCommandResponseBody = "{{range .}}{{template \"result\" .}}\n{{else}}No networks configured{{end}}"
+	statusCommandResponseHTML = "{{if .}}<ul>{{range .}}<li>{{template \"result_html\" .}}</li>{{end}}</ul>{{else}}No networks configured{{end}}"
+
+	resultBody = "{{.Network}}: {{with .Result}}" +
+		"{{if .Success}}ok, RTT {{formatDuration .Delay.RTT}}{{else}}failed ({{.Reason}}){{end}}" +
+		" at {{formatTime .Time}}{{else}}not probed yet{{end}}"
+	resultHTML = "<code>{{.Network | html}}</code>: {{with .Result}}" +
+		"{{if .Success}}<strong>ok</strong>, RTT {{formatDuration .Delay.RTT}}{{else}}<strong>failed</strong> (<code>{{.Reason}}</code>){{end}}" +
+		" at {{formatTime .Time}}{{else}}<em>not probed yet</em>{{end}}"
+
+	roomsCommandResponseBody = "{{range .}}{{.Name}}: {{.RoomID}}\n{{else}}No rooms configured{{end}}"
+	roomsCommandResponseHTML = "{{if .}}<ul>{{range .}}<li><code>{{.Name | html}}</code>: " +
+		"<a href='https://matrix.to/#/{{.RoomID}}'>{{.RoomID | html}}</a></li>{{end}}</ul>{{else}}No rooms configured{{end}}"
+
+	silencesCommandResponseBody = "{{range $n, $t := .}}{{$n}} silenced until {{formatTime $t}}\n{{else}}No active silences{{end}}"
+	silencesCommandResponseHTML = "{{if .}}<ul>{{range $n, $t := .}}<li><code>{{$n | html}}</code> silenced until " +
+		"{{formatTime $t}}</li>{{end}}</ul>{{else}}No active silences{{end}}"
+)
+
+var adminTemplate *template.Template
+
+func init() {
+	adminTemplate = template.New("").Funcs(template.FuncMap{
+		"formatDuration": formatDuration,
+		"formatTime":     func(t time.Time) string { return t.Format(time.RFC3339) },
+	})
+	adminTemplate = template.Must(adminTemplate.New("result").Parse(resultBody))
+	adminTemplate = template.Must(adminTemplate.New("result_html").Parse(resultHTML))
+	adminTemplate = template.Must(adminTemplate.New("status").Parse(statusCommandResponseBody))
+	adminTemplate = template.Must(adminTemplate.New("status_html").Parse(statusCommandResponseHTML))
+	adminTemplate = template.Must(adminTemplate.New("rooms").Parse(roomsCommandResponseBody))
+	adminTemplate = template.Must(adminTemplate.New("rooms_html").Parse(roomsCommandResponseHTML))
+	adminTemplate = template.Must(adminTemplate.New("silences").Parse(silencesCommandResponseBody))
+	adminTemplate = template.Must(adminTemplate.New("silences_html").Parse(silencesCommandResponseHTML))
+}
+
+// Backend provides the probe results for the admin commands.
+type Backend interface {
+	// Results returns the latest result of every network, by network name.
+	// Networks that have not been probed yet have a nil result.
+	Results() map[string]*ping.Result
+
+	// ProbeNetwork probes a single network, and returns the result.
+	ProbeNetwork(ctx context.Context, network string) (*ping.Result, error)
+}
+
+// Silencer silences alerts for the admin commands.
+type Silencer interface {
+	// Silence silences the alerts of a network for a duration, and returns the end of the silence.
+	Silence(network string, d time.Duration) time.Time
+
+	// Unsilence removes the silence of a network, and returns false if it was not silenced.
+	Unsilence(network string) bool
+
+	// Silences returns the end times of the active silences, by network.
+	Silences() map[string]time.Time
+}
+
+// networkResult is the result of a network, as shown by the admin commands.
+type networkResult struct {
+	Network string
+	Result  *ping.Result
+}
+
+// configuredRoom is a configured room, as shown by the admin commands.
+type configuredRoom struct {
+	Name   string
+	RoomID id.RoomID
+}
+
+// EnableAdmin registers the admin commands, answered for the configured admins only.
+func (c *Client) EnableAdmin(backend Backend) {
+	c.HandleCommand(StatusCommand, c.admin(func(ctx context.Context, e *event.Event, _ []string) error {
+		return c.statusHandler(ctx, e, backend)
+	}))
+	c.HandleCommand(ProbeCommand, c.admin(func(ctx context.Context, e *event.Event, args []string) error {
+		return c.probeHandler(e, args, backend)
+	}))
+	c.HandleCommand(RoomsCommand, c.admin(c.roomsHandler))
+}
+
+// EnableSilence registers the silence commands,
+// answered for anyone in the given room, and the configured admins in any room.
+func (c *Client) EnableSilence(silencer Silencer, roomID id.RoomID) {
+	c.HandleCommand(SilenceCommand, c.adminOrRoom(roomID, func(ctx context.Context, e *event.Event, args []string) error {
+		return c.silenceHandler(ctx, e, args, silencer)
+	}))
+	c.HandleCommand(UnsilenceCommand, c.adminOrRoom(roomID, func(ctx context.Context, e *event.Event, args []string) error {
+		return c.unsilenceHandler(ctx, e, args, silencer)
+	}))
+}
+
+// admin wraps a command handler, so that it only handles commands from admins.
+func (c *Client) admin(h CommandHandler) CommandHandler {
+	return func(ctx context.Context, e *event.Event, args []string) error {
+		if !c.isAdmin(e.Sender) {
+			slog.Debug("Ignoring command from non-admin", "sender", e.Sender, "room_id", e.RoomID)
+			return nil
+		}
+
+		return h(ctx, e, args)
+	}
+}
+
+// adminOrRoom wraps a command handler, so that it only handles commands from admins,
+// or from anyone in the given room.
+func (c *Client) adminOrRoom(roomID id.RoomID, h CommandHandler) CommandHandler {
+	return func(ctx context.Context, e *event.Event, args []string) error {
+		if e.RoomID != roomID && !c.isAdmin(e.Sender) {
+			slog.Debug("Ignoring command from non-admin", "sender", e.Sender, "room_id", e.RoomID)
+			return nil
+		}
+
+		return h(ctx, e, args)
+	}
+}
+
+// isAdmin returns true if a user is allowed to use the admin commands.
+func (c *Client) isAdmin(user id.UserID) bool {
+	for _, a := range c.admins {
+		if a == user {
+			return true
+		}
+	}
+	return false
+}
+
+// statusHandler handles the status command.
+func (c *Client) statusHandler(ctx context.Context, e *event.Event, backend Backend) error {
+	results := backend.Results()
+
+	list := make([]*networkResult, 0, len(results))
+	for n, r := range results {
+		list = append(list, &networkResult{Network: n, Result: r})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Network < list[j].Network
+	})
+
+	return c.sendTemplate(ctx, e.RoomID, "status", list)
+}
+
+// probeHandler handles the probe command.
+// The probe runs in the background, so the sync is not blocked while waiting for replies.
+func (c *Client) probeHandler(e *event.Event, args []string, backend Backend) error {
+	if len(args) == 0 {
+		_, err := c.SendText(context.Background(), e.RoomID, "Usage: "+ProbeCommand+" <network>")
+		return err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), adminProbeTimeout)
+		defer cancel()
+
+		r, err := backend.ProbeNetwork(ctx, args[0])
+		if err != nil {
+			_, err = c.SendText(ctx, e.RoomID, fmt.Sprintf("Error probing %s: %s", args[0], err))
+		} else {
+			err = c.sendTemplate(ctx, e.RoomID, "result", &networkResult{Network: args[0], Result: r})
+		}
+
+		if err != nil {
+			slog.Error("Error sending response", "cmd", ProbeCommand, "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// roomsHandler handles the rooms command.
+func (c *Client) roomsHandler(ctx context.Context, e *event.Event, _ []string) error {
+	rooms := make([]*configuredRoom, 0, len(c.Rooms))
+	for roomID, name := range c.Rooms {
+		rooms = append(rooms, &configuredRoom{Name: name, RoomID: roomID})
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	return c.sendTemplate(ctx, e.RoomID, "rooms", rooms)
+}
+
+// silenceHandler handles the silence command.
+func (c *Client) silenceHandler(ctx context.Context, e *event.Event, args []string, silencer Silencer) error {
+	if len(args) == 0 {
+		return c.sendTemplate(ctx, e.RoomID, "silences", silencer.Silences())
+	}
+
+	if !c.isNetwork(args[0]) {
+		_, err := c.SendText(ctx, e.RoomID, fmt.Sprintf("Unknown network: %q", args[0]))
+		return err
+	}
+
+	duration := DefaultSilence
+	if len(args) > 1 {
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			_, err = c.SendText(ctx, e.RoomID, fmt.Sprintf("Invalid duration: %q", args[1]))
+			return err
+		}
+		duration = d
+	}
+
+	until := silencer.Silence(args[0], duration)
+	return c.sendTemplate(ctx, e.RoomID, "silences", map[string]time.Time{args[0]: until})
+}
+
+// unsilenceHandler handles the unsilence command.
+func (c *Client) unsilenceHandler(ctx context.Context, e *event.Event, args []string, silencer Silencer) error {
+	if len(args) == 0 {
+		_, err := c.SendText(ctx, e.RoomID, "Usage: "+UnsilenceCommand+" <network|all>")
+		return err
+	}
+
+	if !c.isNetwork(args[0]) {
+		_, err := c.SendText(ctx, e.RoomID, fmt.Sprintf("Unknown network: %q", args[0]))
+		return err
+	}
+
+	msg := fmt.Sprintf("Removed silence for %s", args[0])
+	if !silencer.Unsilence(args[0]) {
+		msg = fmt.Sprintf("Alerts for %s are not silenced", args[0])
+	}
+
+	_, err := c.SendText(ctx, e.RoomID, msg)
+	return err
+}
+
+// isNetwork returns true if a name is a configured network, or all networks.
+func (c *Client) isNetwork(name string) bool {
+	if name == AllNetworks {
+		return true
+	}
+
+	for _, n := range c.Rooms {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sendTemplate sends a message using the plain text and HTML variant of an admin template.
+func (c *Client) sendTemplate(ctx context.Context, roomID id.RoomID, name string, data any) error {
+	var plain, formatted strings.Builder
+	if err := adminTemplate.ExecuteTemplate(&plain, name, data); err != nil {
+		return err
+	}
+	if err := adminTemplate.ExecuteTemplate(&formatted, name+"_html", data); err != nil {
+		return err
+	}
+
+	_, err := c.SendHTML(ctx, roomID, strings.TrimSpace(plain.String()), formatted.String())
+	return err
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"maunium.net/go/mautrix/event"
@@ -16,10 +19,10 @@ import (
 
 const (
 	// PingMessage contains the prefix for a ping message
-	PingMessage = "ping"
+	PingMessage = ping.PingMessage
 
 	// PingResponse contains the expected response prefix to a ping message
-	PingResponse = "pong"
+	PingResponse = ping.PingResponse
 
 	// PingCommand is the prefix for a ping command
 	PingCommand = "!ping"
@@ -35,8 +38,32 @@ type Client struct {
 	Rooms        map[id.RoomID]string
 	Pings, Pongs chan *ping.Message
 	messageType  event.MessageType
+	respond      bool
+	probeUsers   map[id.UserID]bool
+	signer       *ping.Signer
+	version      int
+	admins       []id.UserID
+
+	dropped   atomic.Uint64
+	limited   atomic.Uint64
+	limiter   *limiter
+	pingStats *pingStats
+
+	defaultPingTemplate *template.Template
+	roomPingTemplates   map[id.RoomID]*template.Template
+
+	commandsMu sync.RWMutex
+	commands   map[string]CommandHandler
+
+	callbacksMu     sync.RWMutex
+	onMalformedPong func(room string)
+	onRejected      func(room, reason string)
 }
 
+// CommandHandler handles a command sent to the client.
+// The arguments are the words following the command.
+type CommandHandler func(ctx context.Context, e *event.Event, args []string) error
+
 // Config is used for the configuration of the Matrix client
 type Config struct {
 	Homeserver  string
@@ -44,6 +71,49 @@ type Config struct {
 	Token       string
 	MessageType event.MessageType
 	Rooms       map[string]id.RoomID
+
+	// Respond enables replies to ping messages from the probe users in the configured rooms.
+	// This is required for reverse probes from IRC.
+	Respond bool
+
+	// Secret is used to sign and verify ping messages.
+	// Ping messages are not signed if this is empty.
+	Secret string
+
+	// Version is the version of the wire format used for pings.
+	// Defaults to ping.Version.
+	Version int
+
+	// Admins contains the users that are allowed to use the admin commands.
+	Admins []id.UserID
+
+	// PingTemplate configures the response to the ping command.
+	PingTemplate *PingTemplate
+
+	// RoomPingTemplates overrides the response to the ping command by room ID.
+	// Empty fields are taken from PingTemplate.
+	RoomPingTemplates map[id.RoomID]*PingTemplate
+
+	// Locales contains additional locales, or overrides of the built-in Locales, by language code.
+	Locales map[string]*Locale
+
+	// UserLimit and RoomLimit limit the commands answered per user and per room.
+	// Defaults to DefaultUserLimit and DefaultRoomLimit.
+	UserLimit, RoomLimit *RateLimit
+
+	// SendLimit is the budget of all sent messages.
+	// Probes are always sent, but commands are not answered when the budget is used up.
+	// Defaults to DefaultSendLimit.
+	SendLimit *RateLimit
+
+	// AllowRooms and AllowUsers restrict commands to the given rooms and users, if not empty.
+	// DenyRooms and DenyUsers contain the rooms and users that commands are ignored from.
+	AllowRooms, DenyRooms []id.RoomID
+	AllowUsers, DenyUsers []id.UserID
+
+	// ProbeUsers contains the users that send probes, such as the bridged IRC users of reverse probes.
+	// Only pings from these users are replied to.
+	ProbeUsers []id.UserID
 }
 
 // Message represents a Matrix Message
@@ -56,9 +126,26 @@ type Message struct {
 func NewClient(config *Config) (c *Client, err error) {
 	c = &Client{
 		messageType: config.MessageType,
+		respond:     config.Respond,
+		probeUsers:  toSet(config.ProbeUsers),
+		signer:      ping.NewSigner(config.Secret),
+		version:     config.Version,
+		admins:      config.Admins,
 		Rooms:       make(map[id.RoomID]string, len(config.Rooms)),
 		Pings:       make(chan *ping.Message, 25),
 		Pongs:       make(chan *ping.Message, 25),
+		commands:    make(map[string]CommandHandler),
+		pingStats:   newPingStats(),
+		limiter:     newLimiter(config),
+	}
+
+	if c.version == 0 {
+		c.version = ping.Version
+	}
+
+	// Parse the ping command response templates
+	if err = c.parseTemplates(config); err != nil {
+		return
 	}
 
 	// Add Rooms to map with id/name swapped
@@ -90,11 +177,100 @@ func NewClient(config *Config) (c *Client, err error) {
 	return
 }
 
-// SendPing sends a ping message
+// parseTemplates parses the configured ping command response templates.
+func (c *Client) parseTemplates(config *Config) (err error) {
+	def := new(PingTemplate)
+	if config.PingTemplate != nil {
+		def = config.PingTemplate
+	}
+
+	c.defaultPingTemplate, err = newPingTemplate(def, config.Locales)
+	if err != nil {
+		return fmt.Errorf("invalid ping template: %w", err)
+	}
+
+	c.roomPingTemplates = make(map[id.RoomID]*template.Template, len(config.RoomPingTemplates))
+	for roomID, t := range config.RoomPingTemplates {
+		if t == nil {
+			t = new(PingTemplate)
+		}
+		c.roomPingTemplates[roomID], err = newPingTemplate(t.merge(def), config.Locales)
+		if err != nil {
+			return fmt.Errorf("invalid ping template for room %s: %w", roomID, err)
+		}
+	}
+
+	return nil
+}
+
+// Dropped returns the number of ping messages that were dropped
+// because the Pings or Pongs channel was full.
+func (c *Client) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// Limited returns the number of commands that were ignored
+// because of the rate limits, or the allow and deny lists.
+func (c *Client) Limited() uint64 {
+	return c.limited.Load()
+}
+
+// SendPing sends a ping message.
+// Pings are never rate limited, but use the send budget.
 func (c *Client) SendPing(ctx context.Context, roomID id.RoomID, pingID string, ts time.Time) (*matrix.RespSendEvent, error) {
 	slog.Debug("Sending ping", "ping_id", pingID, "room_id", roomID)
+	c.limiter.reserve()
 
-	return c.SendText(ctx, roomID, fmt.Sprintf("%s %s %d", PingMessage, pingID, ts.UnixNano()))
+	p := &ping.Packet{Kind: PingMessage, Version: c.version, ID: pingID, Time: ts, Source: c.UserID.String()}
+	return c.SendText(ctx, roomID, c.signer.Sign(p.String()))
+}
+
+// HandleCommand registers a handler for a command, such as `!silence`.
+// Commands sent as notices are ignored.
+func (c *Client) HandleCommand(cmd string, h CommandHandler) {
+	c.commandsMu.Lock()
+	defer c.commandsMu.Unlock()
+
+	c.commands[cmd] = h
+}
+
+// HandleMalformedPong registers a function that is called with the room name
+// when an invalid ping reply is received in one of the configured rooms.
+func (c *Client) HandleMalformedPong(f func(room string)) {
+	c.callbacksMu.Lock()
+	defer c.callbacksMu.Unlock()
+
+	c.onMalformedPong = f
+}
+
+// HandleRejected registers a function that is called with the room name and reason
+// when a message with an invalid signature, or a replayed message is received.
+func (c *Client) HandleRejected(f func(room, reason string)) {
+	c.callbacksMu.Lock()
+	defer c.callbacksMu.Unlock()
+
+	c.onRejected = f
+}
+
+// command returns the handler for a command, if registered.
+func (c *Client) command(cmd string) (CommandHandler, bool) {
+	c.commandsMu.RLock()
+	defer c.commandsMu.RUnlock()
+
+	h, ok := c.commands[cmd]
+	return h, ok
+}
+
+// SendHTML sends a message with a plain text and an HTML formatted body
+func (c *Client) SendHTML(ctx context.Context, roomID id.RoomID, text, html string) (*matrix.RespSendEvent, error) {
+	return c.SendMessageEvent(ctx, roomID, event.EventMessage,
+		&event.MessageEventContent{
+			MsgType:       c.messageType,
+			Body:          text,
+			Format:        HTMLFormat,
+			FormattedBody: html,
+		},
+	)
 }
 
 // SendText sends a plain text message
@@ -2,8 +2,9 @@ package matrix
 
 import (
 	"context"
-	"fmt"
 	"html"
+	"io"
+	"log/slog"
 	"strings"
 	"text/template"
 	"time"
@@ -13,22 +14,111 @@ import (
 )
 
 const (
-	pingCommandResponseBody          = "{{.Sender}}: Pong! (ping {{.Message}} {{formatDuration .Duration}} to arrive)"
+	pingCommandResponseBody          = "{{.Sender}}: Pong! (ping {{with .Text}}\"{{.}}\" {{end}}took {{formatDuration .Duration}} to arrive)"
 	pingCommandResponseFormattedBody = "<a href='https://matrix.to/#/{{.Sender}}'>{{.Sender}}</a>: Pong! " +
-		"(<a href='https://matrix.to/#/{{.RoomID}}/{{.ID}}'>ping</a> {{.Message}} {{formatDuration .Duration}} to arrive)"
+		"(<a href='https://matrix.to/#/{{.RoomID}}/{{.ID}}'>ping</a> {{with .Text}}\"{{.}}\" {{end}}took {{formatDuration .Duration}} to arrive)"
 )
 
-var pingTemplate *template.Template
+// builtinPingTemplate contains the default ping command response templates,
+// used when a configured template fails to execute.
+var builtinPingTemplate = template.Must(newPingTemplate(new(PingTemplate), nil))
+
+// samplePingEvent is used to check that the ping command response templates can be executed.
+var samplePingEvent = &pingEvent{
+	Event: &event.Event{
+		Sender:    "@user:example.com",
+		RoomID:    "!room:example.com",
+		ID:        "$event",
+		Timestamp: 1700000000000,
+	},
+	Message:  `"text" took`,
+	Text:     "text",
+	Duration: 1234 * time.Millisecond,
+}
+
+// PingTemplate configures the response to the ping command.
+type PingTemplate struct {
+	// Body and HTML are the templates of the plain text and HTML formatted response.
+	// The default templates are used if these are empty.
+	Body, HTML string
+
+	// Locale is the language code of the locale used for durations.
+	// Defaults to DefaultLocale.
+	Locale string
+}
+
+// merge returns the template with the empty fields taken from another template.
+func (t PingTemplate) merge(def *PingTemplate) *PingTemplate {
+	if def == nil {
+		return &t
+	}
+	if t.Body == "" {
+		t.Body = def.Body
+	}
+	if t.HTML == "" {
+		t.HTML = def.HTML
+	}
+	if t.Locale == "" {
+		t.Locale = def.Locale
+	}
+	return &t
+}
+
+// newPingTemplate parses the templates of a ping command response.
+func newPingTemplate(conf *PingTemplate, locales map[string]*Locale) (*template.Template, error) {
+	body, formatted := pingCommandResponseBody, pingCommandResponseFormattedBody
+	if conf.Body != "" {
+		body = conf.Body
+	}
+	if conf.HTML != "" {
+		formatted = conf.HTML
+	}
+
+	locale, err := getLocale(conf.Locale, locales)
+	if err != nil {
+		return nil, err
+	}
+
+	t := template.New("").Funcs(template.FuncMap{"formatDuration": locale.FormatDuration})
+	if t, err = t.New("text").Parse(body); err != nil {
+		return nil, err
+	}
+	if t, err = t.New("html").Parse(formatted); err != nil {
+		return nil, err
+	}
+
+	// Execute the templates once, so errors are reported at startup
+	for _, name := range []string{"text", "html"} {
+		if err = t.ExecuteTemplate(io.Discard, name, samplePingEvent); err != nil {
+			return nil, err
+		}
+	}
 
-func init() {
-	pingTemplate = template.New("").Funcs(template.FuncMap{"formatDuration": formatDuration})
-	pingTemplate = template.Must(pingTemplate.New("text").Parse(pingCommandResponseBody))
-	pingTemplate = template.Must(pingTemplate.New("html").Parse(pingCommandResponseFormattedBody))
+	return t, nil
+}
+
+// executePingTemplate returns the plain text and HTML ping command response.
+func executePingTemplate(t *template.Template, ev *pingEvent) (string, string, error) {
+	var plain, formatted strings.Builder
+	if err := t.ExecuteTemplate(&plain, "text", ev); err != nil {
+		return "", "", err
+	}
+	if err := t.ExecuteTemplate(&formatted, "html", ev); err != nil {
+		return "", "", err
+	}
+	return plain.String(), formatted.String(), nil
 }
 
+// pingEvent contains the data for the ping command response templates.
 type pingEvent struct {
 	*event.Event
-	Message  string
+
+	// Message is the English description of the ping, for compatibility with older templates.
+	Message string
+
+	// Text is the text sent with the ping command, if any.
+	Text string
+
 	Duration time.Duration
 }
 
@@ -56,23 +146,29 @@ func (c *Client) pingHandler(ctx context.Context, e *event.Event, received time.
 			body = body[:32]
 		}
 		ev.Message = `"` + body + `" took`
+		ev.Text = body
 	}
 
 	// Calculate time difference
 	ev.Duration = received.Sub(time.Unix(0, ev.Timestamp*1e6))
-
-	// Create response
-	var plain, formatted strings.Builder
-	_ = pingTemplate.ExecuteTemplate(&plain, "text", ev)
-	_ = pingTemplate.ExecuteTemplate(&formatted, "html", ev)
+	c.pingStats.add(e.RoomID, ev.Sender.Homeserver(), ev.Duration)
+
+	// Create response, falling back to the built-in templates on errors
+	plain, formatted, err := executePingTemplate(c.pingTemplate(e.RoomID), ev)
+	if err != nil {
+		slog.Error("Error executing ping template, using the default", "room_id", e.RoomID, "err", err)
+		if plain, formatted, err = executePingTemplate(builtinPingTemplate, ev); err != nil {
+			return err
+		}
+	}
 
 	// Create response
 	response := &pingMessage{
 		MessageEventContent: event.MessageEventContent{
 			MsgType:       c.messageType,
-			Body:          html.EscapeString(plain.String()),
+			Body:          html.EscapeString(plain),
 			Format:        HTMLFormat,
-			FormattedBody: formatted.String(),
+			FormattedBody: formatted,
 		},
 		Pong: pongData{
 			Milliseconds: ev.Duration.Milliseconds(),
@@ -81,7 +177,7 @@ func (c *Client) pingHandler(ctx context.Context, e *event.Event, received time.
 		},
 	}
 
-	_, err := c.SendMessageEvent(ctx, e.RoomID, event.EventMessage, response)
+	_, err = c.SendMessageEvent(ctx, e.RoomID, event.EventMessage, response)
 	return err
 }
 
@@ -89,13 +185,15 @@ func getArgs(e *event.Event, n int) []string {
 	return strings.SplitN(e.Content.AsMessage().Body, " ", n+1)[1:]
 }
 
-func formatDuration(d time.Duration) string {
-	switch {
-	case d < 10*time.Second:
-		return fmt.Sprintf("%d ms", d.Milliseconds())
-	case d < 1*time.Minute:
-		return fmt.Sprintf("%.1f second", d.Seconds())
-	default:
-		return fmt.Sprintf("%6s", d.Truncate(time.Second))
+// pingTemplate returns the ping command response templates for a room.
+func (c *Client) pingTemplate(roomID id.RoomID) *template.Template {
+	if t, ok := c.roomPingTemplates[roomID]; ok {
+		return t
 	}
+	return c.defaultPingTemplate
+}
+
+// formatDuration formats a duration using the default locale.
+func formatDuration(d time.Duration) string {
+	return Locales[DefaultLocale].FormatDuration(d)
 }
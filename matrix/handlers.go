@@ -2,8 +2,8 @@ package matrix
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
-	"strconv"
 	"strings"
 	"time"
 
@@ -25,28 +25,41 @@ func (c *Client) messageHandler(ctx context.Context, e *event.Event) {
 
 	// Get command from body
 	cmd := strings.SplitN(msg.Body, " ", 2)[0]
+	if ping.IsPacket(cmd) {
+		cmd, _, _ = strings.Cut(cmd, "/")
+	}
 
 	slog.Debug("Received message", "cmd", cmd, "room_id", e.RoomID)
 
 	var err error
 	switch cmd {
 	case PingMessage, PingResponse:
-		msg := c.parseMessage(e, now)
+		p, msg := c.parseMessage(e, now)
 		switch {
 		case msg == nil:
 			// ignore
 		case cmd == PingMessage:
-			c.Pings <- msg
+			c.deliver(c.Pings, msg)
+			err = c.respondPing(ctx, e, p, now)
 		case cmd == PingResponse:
-			c.Pongs <- msg
+			c.deliver(c.Pongs, msg)
 		}
 	case PingCommand:
-		// Ignore notice messages
-		if msg.MsgType == event.MsgNotice {
-			slog.Debug("Ignoring notice message", "event_id", e.ID, "room_id", e.RoomID)
+		if !c.allowCommand(e, msg) {
 			return
 		}
 		err = c.pingHandler(ctx, e, now)
+	case PingStatsCommand:
+		if !c.allowCommand(e, msg) {
+			return
+		}
+		err = c.pingStatsHandler(ctx, e)
+	default:
+		h, ok := c.command(cmd)
+		if !ok || !c.allowCommand(e, msg) {
+			return
+		}
+		err = h(ctx, e, strings.Fields(msg.Body)[1:])
 	}
 
 	if err != nil {
@@ -54,37 +67,109 @@ func (c *Client) messageHandler(ctx context.Context, e *event.Event) {
 	}
 }
 
-func (c *Client) parseMessage(e *event.Event, received time.Time) *ping.Message {
+// allowCommand checks if a command should be answered.
+// Notice messages are ignored, as are commands that are rate limited,
+// or not allowed by the allow and deny lists.
+func (c *Client) allowCommand(e *event.Event, msg *event.MessageEventContent) bool {
+	if msg.MsgType == event.MsgNotice {
+		slog.Debug("Ignoring notice message", "event_id", e.ID, "room_id", e.RoomID)
+		return false
+	}
+
+	if !c.limiter.allow(e) {
+		slog.Info("Ignoring limited command", "event_id", e.ID, "room_id", e.RoomID, "sender", e.Sender)
+		c.limited.Add(1)
+		return false
+	}
+
+	return true
+}
+
+// respondPing replies to a ping message from a probe user, if enabled.
+// Pings from other users, such as other exporters, are ignored,
+// as they expect a reply from IRC.
+// Replies are part of reverse probes, so they are never rate limited, but use the send budget.
+func (c *Client) respondPing(ctx context.Context, e *event.Event, p *ping.Packet, received time.Time) error {
+	if !c.respond || !c.probeUsers[e.Sender] {
+		return nil
+	}
+
+	c.limiter.reserve()
+
+	resp := c.signer.Sign(p.Reply(received, time.Now(), c.UserID.String()).String())
+	slog.Debug("Sending ping reply", "room_id", e.RoomID, "response", resp)
+
+	_, err := c.SendText(ctx, e.RoomID, resp)
+	return err
+}
+
+// parseMessage parses a ping message received in one of the configured rooms.
+func (c *Client) parseMessage(e *event.Event, received time.Time) (*ping.Packet, *ping.Message) {
 	// Ignore message if not received in the configured Rooms
 	room, ok := c.Rooms[e.RoomID]
 	if !ok {
 		slog.Debug("Ignoring message", "event_id", e.ID, "room_id", e.RoomID)
-		return nil
+		return nil, nil
 	}
 
-	// Ignore message if not all components are available
-	msg := e.Content.AsMessage()
-	parts := strings.Split(msg.Body, " ")
-	if len(parts) < 3 {
-		slog.Debug("Invalid message", "event_id", e.ID, "room_id", e.RoomID, "parts", parts)
-		return nil
+	// Verify the signature of the message
+	body, err := c.signer.Verify(e.Content.AsMessage().Body)
+	if err != nil {
+		slog.Warn("Rejecting message", "event_id", e.ID, "room_id", e.RoomID, "sender", e.Sender, "err", err)
+		c.rejected(room, err)
+		return nil, nil
 	}
 
-	// Parse timestamp
-	ts, err := strconv.ParseInt(parts[2], 0, 64)
+	// Ignore message if not all components are available
+	p, err := ping.Parse(body)
+	if err == nil && p.Time.IsZero() {
+		err = fmt.Errorf("%w: timestamp", ping.ErrMissingField)
+	}
 	if err != nil {
-		slog.Info("Received pong with invalid time", "body", msg.Body)
-		return nil
+		slog.Debug("Invalid message", "event_id", e.ID, "room_id", e.RoomID, "body", body, "err", err)
+		c.malformed(ping.IsResponse(body), room)
+		return nil, nil
 	}
 
 	// Assemble message
-	return &ping.Message{
-		Kind:     parts[0],
-		ID:       parts[1],
-		Sent:     time.Unix(0, ts),
-		Matrix:   time.Unix(0, e.Timestamp*1e6),
-		Room:     room,
-		Received: received,
+	msg := p.Message(room)
+	msg.Matrix = time.Unix(0, e.Timestamp*1e6)
+	msg.Received = received
+	msg.Sender = e.Sender.String()
+
+	return p, msg
+}
+
+// deliver sends a message to a channel without blocking the sync.
+// The message is dropped if the channel is full.
+func (c *Client) deliver(ch chan<- *ping.Message, msg *ping.Message) {
+	select {
+	case ch <- msg:
+	default:
+		c.dropped.Add(1)
+		slog.Warn("Dropping message", "kind", msg.Kind, "ping_id", msg.ID, "room", msg.Room)
+	}
+}
+
+// rejected reports a message with an invalid signature, or a replayed message.
+func (c *Client) rejected(room string, err error) {
+	c.callbacksMu.RLock()
+	f := c.onRejected
+	c.callbacksMu.RUnlock()
+
+	if f != nil {
+		f(room, ping.RejectReason(err))
+	}
+}
+
+// malformed reports a malformed ping message.
+func (c *Client) malformed(response bool, room string) {
+	c.callbacksMu.RLock()
+	f := c.onMalformedPong
+	c.callbacksMu.RUnlock()
+
+	if response && f != nil {
+		f(room)
 	}
 }
 
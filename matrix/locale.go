@@ -0,0 +1,112 @@
+package matrix
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLocale is the locale used for durations if no locale is configured.
+const DefaultLocale = "en"
+
+// Locale contains the words used to format durations in a language.
+type Locale struct {
+	// Millisecond is the abbreviation of milliseconds.
+	Millisecond string
+
+	// Second, Minute and Hour are the singular units,
+	// Seconds, Minutes and Hours the plural units.
+	Second, Seconds string
+	Minute, Minutes string
+	Hour, Hours     string
+
+	// Decimal is the decimal separator.
+	Decimal string
+}
+
+// Locales contains the built-in locales, by language code.
+var Locales = map[string]*Locale{
+	"en": {Millisecond: "ms", Second: "second", Seconds: "seconds", Minute: "minute", Minutes: "minutes", Hour: "hour", Hours: "hours", Decimal: "."},
+	"nl": {Millisecond: "ms", Second: "seconde", Seconds: "seconden", Minute: "minuut", Minutes: "minuten", Hour: "uur", Hours: "uur", Decimal: ","},
+	"de": {Millisecond: "ms", Second: "Sekunde", Seconds: "Sekunden", Minute: "Minute", Minutes: "Minuten", Hour: "Stunde", Hours: "Stunden", Decimal: ","},
+	"fr": {Millisecond: "ms", Second: "seconde", Seconds: "secondes", Minute: "minute", Minutes: "minutes", Hour: "heure", Hours: "heures", Decimal: ","},
+	"es": {Millisecond: "ms", Second: "segundo", Seconds: "segundos", Minute: "minuto", Minutes: "minutos", Hour: "hora", Hours: "horas", Decimal: ","},
+}
+
+// FormatDuration formats a duration in a human-readable way.
+// Short durations are given in milliseconds, durations below a minute in
+// seconds with one decimal, and longer durations in hours, minutes and seconds.
+func (l *Locale) FormatDuration(d time.Duration) string {
+	switch {
+	case d < 10*time.Second:
+		return fmt.Sprintf("%d %s", d.Milliseconds(), l.Millisecond)
+	case d < 1*time.Minute:
+		s := strconv.FormatFloat(d.Seconds(), 'f', 1, 64)
+		return strings.Replace(s, ".", l.Decimal, 1) + " " + l.Seconds
+	}
+
+	d = d.Truncate(time.Second)
+	h, m, s := int(d/time.Hour), int(d/time.Minute%60), int(d/time.Second%60)
+
+	var parts []string
+	if h > 0 {
+		parts = append(parts, l.plural(h, l.Hour, l.Hours))
+	}
+	if m > 0 {
+		parts = append(parts, l.plural(m, l.Minute, l.Minutes))
+	}
+	if s > 0 {
+		parts = append(parts, l.plural(s, l.Second, l.Seconds))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// plural formats a count with the singular or plural unit.
+func (l *Locale) plural(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return strconv.Itoa(n) + " " + plural
+}
+
+// getLocale returns a configured or built-in locale by language code.
+// Missing words of a configured locale are taken from the built-in locale
+// for the same language, or the default locale.
+func getLocale(name string, configured map[string]*Locale) (*Locale, error) {
+	if name == "" {
+		name = DefaultLocale
+	}
+
+	builtin, ok := Locales[name]
+	custom := configured[name]
+	hasCustom := custom != nil
+	switch {
+	case !ok && !hasCustom:
+		return nil, fmt.Errorf("unknown locale: %q", name)
+	case !hasCustom:
+		return builtin, nil
+	case !ok:
+		builtin = Locales[DefaultLocale]
+	}
+
+	l := *custom
+	setDefault(&l.Millisecond, builtin.Millisecond)
+	setDefault(&l.Second, builtin.Second)
+	setDefault(&l.Seconds, builtin.Seconds)
+	setDefault(&l.Minute, builtin.Minute)
+	setDefault(&l.Minutes, builtin.Minutes)
+	setDefault(&l.Hour, builtin.Hour)
+	setDefault(&l.Hours, builtin.Hours)
+	setDefault(&l.Decimal, builtin.Decimal)
+
+	return &l, nil
+}
+
+// setDefault sets a string to a default value if it is empty.
+func setDefault(s *string, def string) {
+	if *s == "" {
+		*s = def
+	}
+}
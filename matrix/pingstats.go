@@ -0,0 +1,162 @@
+package matrix
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+const (
+	// PingStatsCommand is the prefix for a ping statistics command
+	PingStatsCommand = "!pingstats"
+
+	// pingStatsSamples is the maximum number of delays kept per homeserver for the median.
+	pingStatsSamples = 1000
+
+	// pingStatsRows is the maximum number of homeservers in the ping statistics.
+	pingStatsRows = 20
+)
+
+const (
+	pingStatsResponseBody = "{{if .}}Ping statistics by homeserver:\n" +
+		"{{range $i, $s := .}}{{inc $i}}. {{$s.Server}}: median {{formatDuration $s.Median}} " +
+		"({{$s.Count}} pings, best {{formatDuration $s.Best}}, worst {{formatDuration $s.Worst}})\n{{end}}" +
+		"{{else}}No pings yet{{end}}"
+	pingStatsResponseFormattedBody = "{{if .}}<table><thead><tr><th>#</th><th>Homeserver</th><th>Median</th>" +
+		"<th>Count</th><th>Best</th><th>Worst</th></tr></thead><tbody>" +
+		"{{range $i, $s := .}}<tr><td>{{inc $i}}</td><td>{{$s.Server | html}}</td><td>{{formatDuration $s.Median}}</td>" +
+		"<td>{{$s.Count}}</td><td>{{formatDuration $s.Best}}</td><td>{{formatDuration $s.Worst}}</td></tr>{{end}}" +
+		"</tbody></table>{{else}}No pings yet{{end}}"
+)
+
+var pingStatsTemplate *template.Template
+
+func init() {
+	pingStatsTemplate = template.New("").Funcs(template.FuncMap{
+		"formatDuration": formatDuration,
+		"inc":            func(i int) int { return i + 1 },
+	})
+	pingStatsTemplate = template.Must(pingStatsTemplate.New("text").Parse(pingStatsResponseBody))
+	pingStatsTemplate = template.Must(pingStatsTemplate.New("html").Parse(pingStatsResponseFormattedBody))
+}
+
+// pingStats contains the delays of ping commands, by room and sender homeserver.
+type pingStats struct {
+	mu    sync.Mutex
+	rooms map[id.RoomID]map[string]*serverStats
+}
+
+// serverStats contains the delays of ping commands from a single homeserver.
+type serverStats struct {
+	delays      []time.Duration
+	count       int
+	best, worst time.Duration
+}
+
+// serverSummary is the summary of the ping commands from a single homeserver.
+type serverSummary struct {
+	Server              string
+	Count               int
+	Median, Best, Worst time.Duration
+}
+
+// newPingStats returns empty ping statistics.
+func newPingStats() *pingStats {
+	return &pingStats{rooms: make(map[id.RoomID]map[string]*serverStats)}
+}
+
+// add adds the delay of a ping command in a room from a homeserver.
+// Only the latest delays are kept for the median.
+func (p *pingStats) add(roomID id.RoomID, server string, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	servers, ok := p.rooms[roomID]
+	if !ok {
+		servers = make(map[string]*serverStats)
+		p.rooms[roomID] = servers
+	}
+
+	s, ok := servers[server]
+	if !ok {
+		s = &serverStats{best: d, worst: d}
+		servers[server] = s
+	}
+
+	if len(s.delays) == pingStatsSamples {
+		s.delays = s.delays[1:]
+	}
+	s.delays = append(s.delays, d)
+	s.count++
+	s.best = min(s.best, d)
+	s.worst = max(s.worst, d)
+}
+
+// summary returns the statistics of a room by homeserver, ranked by median delay.
+func (p *pingStats) summary(roomID id.RoomID) []*serverSummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	list := make([]*serverSummary, 0, len(p.rooms[roomID]))
+	for server, s := range p.rooms[roomID] {
+		list = append(list, &serverSummary{
+			Server: server,
+			Count:  s.count,
+			Median: median(s.delays),
+			Best:   s.best,
+			Worst:  s.worst,
+		})
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Median == list[j].Median {
+			return list[i].Server < list[j].Server
+		}
+		return list[i].Median < list[j].Median
+	})
+
+	if len(list) > pingStatsRows {
+		list = list[:pingStatsRows]
+	}
+
+	return list
+}
+
+// median returns the median of a list of delays.
+func median(delays []time.Duration) time.Duration {
+	if len(delays) == 0 {
+		return 0
+	}
+
+	sorted := append([]time.Duration(nil), delays...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	n := len(sorted)
+	if n%2 == 0 {
+		return (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return sorted[n/2]
+}
+
+// pingStatsHandler replies with the ping statistics of the room.
+func (c *Client) pingStatsHandler(ctx context.Context, e *event.Event) error {
+	summary := c.pingStats.summary(e.RoomID)
+
+	var plain, formatted strings.Builder
+	_ = pingStatsTemplate.ExecuteTemplate(&plain, "text", summary)
+	_ = pingStatsTemplate.ExecuteTemplate(&formatted, "html", summary)
+
+	_, err := c.SendMessageEvent(ctx, e.RoomID, event.EventMessage, &event.MessageEventContent{
+		MsgType:       c.messageType,
+		Body:          strings.TrimSpace(plain.String()),
+		Format:        HTMLFormat,
+		FormattedBody: formatted.String(),
+	})
+	return err
+}
@@ -0,0 +1,206 @@
+package matrix
+
+import (
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+// maxBuckets is the number of buckets per user or room after which idle buckets are removed.
+const maxBuckets = 1000
+
+var (
+	// DefaultUserLimit is the default rate limit of commands per user.
+	DefaultUserLimit = &RateLimit{Interval: 10 * time.Second, Burst: 3}
+
+	// DefaultRoomLimit is the default rate limit of commands per room.
+	DefaultRoomLimit = &RateLimit{Interval: 2 * time.Second, Burst: 10}
+
+	// DefaultSendLimit is the default budget of all sent messages.
+	DefaultSendLimit = &RateLimit{Interval: 500 * time.Millisecond, Burst: 20}
+)
+
+// RateLimit configures a token bucket.
+type RateLimit struct {
+	// Interval is the time it takes to regain a single token.
+	// The limit is disabled if this is zero.
+	Interval time.Duration
+
+	// Burst is the maximum number of tokens.
+	Burst int
+}
+
+// bucket is a token bucket.
+type bucket struct {
+	limit  *RateLimit
+	tokens float64
+	last   time.Time
+}
+
+// newBucket returns a full token bucket.
+func newBucket(limit *RateLimit, now time.Time) *bucket {
+	return &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// refill adds the tokens regained since the last update.
+func (b *bucket) refill(now time.Time) {
+	if b.limit.Interval <= 0 {
+		return
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(b.limit.Interval)
+	b.tokens = min(b.tokens, float64(b.limit.Burst))
+	b.last = now
+}
+
+// allow takes a token, and returns false if no token is available.
+func (b *bucket) allow(now time.Time) bool {
+	if b.limit.Interval <= 0 {
+		return true
+	}
+
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// reserve takes a token, even if none is available.
+// The bucket can go into debt up to its burst, delaying later calls to allow.
+func (b *bucket) reserve(now time.Time) {
+	if b.limit.Interval <= 0 {
+		return
+	}
+
+	b.refill(now)
+	b.tokens = max(b.tokens-1, -float64(b.limit.Burst))
+}
+
+// full returns true if the bucket has all its tokens.
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+// limiter limits the commands answered by the client.
+// Commands are limited per user and per room, and share the send budget with the probes.
+// Probes are never limited, but use the send budget with priority over commands.
+type limiter struct {
+	mu sync.Mutex
+
+	userLimit, roomLimit *RateLimit
+	users                map[id.UserID]*bucket
+	rooms                map[id.RoomID]*bucket
+	send                 *bucket
+
+	allowRooms, denyRooms map[id.RoomID]bool
+	allowUsers, denyUsers map[id.UserID]bool
+}
+
+// newLimiter returns a limiter for the given configuration.
+func newLimiter(config *Config) *limiter {
+	now := time.Now()
+	return &limiter{
+		userLimit:  orDefault(config.UserLimit, DefaultUserLimit),
+		roomLimit:  orDefault(config.RoomLimit, DefaultRoomLimit),
+		users:      make(map[id.UserID]*bucket),
+		rooms:      make(map[id.RoomID]*bucket),
+		send:       newBucket(orDefault(config.SendLimit, DefaultSendLimit), now),
+		allowRooms: toSet(config.AllowRooms),
+		denyRooms:  toSet(config.DenyRooms),
+		allowUsers: toSet(config.AllowUsers),
+		denyUsers:  toSet(config.DenyUsers),
+	}
+}
+
+// permitted checks a command against the allow and deny lists.
+// An empty allow list allows everything that is not denied.
+func (l *limiter) permitted(e *event.Event) bool {
+	switch {
+	case l.denyRooms[e.RoomID], l.denyUsers[e.Sender]:
+		return false
+	case len(l.allowRooms) > 0 && !l.allowRooms[e.RoomID]:
+		return false
+	case len(l.allowUsers) > 0 && !l.allowUsers[e.Sender]:
+		return false
+	}
+	return true
+}
+
+// allow returns true if a command may be answered.
+// A token is taken from the buckets of the user and room, and the send budget.
+func (l *limiter) allow(e *event.Event) bool {
+	if !l.permitted(e) {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	user := getBucket(l.users, e.Sender, l.userLimit, now)
+	room := getBucket(l.rooms, e.RoomID, l.roomLimit, now)
+
+	// Check all buckets before taking tokens, so a denied command costs nothing
+	for _, b := range []*bucket{user, room, l.send} {
+		b.refill(now)
+		if b.limit.Interval > 0 && b.tokens < 1 {
+			return false
+		}
+	}
+
+	for _, b := range []*bucket{user, room, l.send} {
+		b.allow(now)
+	}
+	return true
+}
+
+// reserve takes a token from the send budget for a probe message.
+func (l *limiter) reserve() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.send.reserve(time.Now())
+}
+
+// getBucket returns the bucket for a key, creating it if needed.
+// Idle buckets are removed when there are too many.
+func getBucket[K comparable](buckets map[K]*bucket, key K, limit *RateLimit, now time.Time) *bucket {
+	if b, ok := buckets[key]; ok {
+		return b
+	}
+
+	if len(buckets) >= maxBuckets {
+		for k, b := range buckets {
+			if b.full(now) {
+				delete(buckets, k)
+			}
+		}
+	}
+
+	b := newBucket(limit, now)
+	buckets[key] = b
+	return b
+}
+
+// orDefault returns the rate limit, or the default if it is not set.
+func orDefault(limit, def *RateLimit) *RateLimit {
+	if limit == nil {
+		return def
+	}
+	return limit
+}
+
+// toSet returns a set of the values in a list.
+func toSet[T comparable](list []T) map[T]bool {
+	set := make(map[T]bool, len(list))
+	for _, v := range list {
+		set[v] = true
+	}
+	return set
+}
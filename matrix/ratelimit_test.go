@@ -0,0 +1,149 @@
+package matrix
+
+import (
+	"testing"
+	"time"
+
+	"maunium.net/go/mautrix/event"
+	"maunium.net/go/mautrix/id"
+)
+
+func TestLimiter(t *testing.T) {
+	disabled := &RateLimit{}
+	limit := func(burst int) *RateLimit { return &RateLimit{Interval: time.Hour, Burst: burst} }
+
+	// A step with probes reserves the send budget for that number of probes,
+	// a step with a user sends a command
+	type step struct {
+		probes int
+		user   id.UserID
+		room   id.RoomID
+		want   bool
+	}
+
+	tests := []struct {
+		name   string
+		config *Config
+		steps  []step
+	}{
+		{
+			name:   "user limit",
+			config: &Config{UserLimit: limit(2), RoomLimit: disabled, SendLimit: disabled},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!r:x", want: false},
+				{user: "@b:x", room: "!r:x", want: true},
+			},
+		},
+		{
+			name:   "room limit",
+			config: &Config{UserLimit: disabled, RoomLimit: limit(2), SendLimit: disabled},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@b:x", room: "!r:x", want: true},
+				{user: "@c:x", room: "!r:x", want: false},
+				{user: "@c:x", room: "!s:x", want: true},
+			},
+		},
+		{
+			name:   "disabled limits",
+			config: &Config{UserLimit: disabled, RoomLimit: disabled, SendLimit: disabled},
+			steps: []step{
+				{probes: 100},
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!r:x", want: true},
+			},
+		},
+		{
+			name:   "denied command takes no tokens",
+			config: &Config{UserLimit: limit(1), RoomLimit: limit(1), SendLimit: disabled},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!s:x", want: false},
+				{user: "@b:x", room: "!s:x", want: true},
+			},
+		},
+		{
+			name:   "probes use the send budget",
+			config: &Config{UserLimit: disabled, RoomLimit: disabled, SendLimit: limit(2)},
+			steps: []step{
+				{probes: 2},
+				{user: "@a:x", room: "!r:x", want: false},
+			},
+		},
+		{
+			name:   "probes go into debt",
+			config: &Config{UserLimit: disabled, RoomLimit: disabled, SendLimit: limit(2)},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: true},
+				{probes: 3},
+				{user: "@a:x", room: "!r:x", want: false},
+			},
+		},
+		{
+			name: "deny lists",
+			config: &Config{
+				UserLimit: disabled, RoomLimit: disabled, SendLimit: disabled,
+				DenyUsers: []id.UserID{"@a:x"}, DenyRooms: []id.RoomID{"!s:x"},
+			},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: false},
+				{user: "@b:x", room: "!s:x", want: false},
+				{user: "@b:x", room: "!r:x", want: true},
+			},
+		},
+		{
+			name: "allow lists",
+			config: &Config{
+				UserLimit: disabled, RoomLimit: disabled, SendLimit: disabled,
+				AllowUsers: []id.UserID{"@a:x"}, AllowRooms: []id.RoomID{"!r:x"},
+			},
+			steps: []step{
+				{user: "@a:x", room: "!r:x", want: true},
+				{user: "@a:x", room: "!s:x", want: false},
+				{user: "@b:x", room: "!r:x", want: false},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newLimiter(test.config)
+			for i, s := range test.steps {
+				for j := 0; j < s.probes; j++ {
+					l.reserve()
+				}
+				if s.user == "" {
+					continue
+				}
+
+				e := &event.Event{Sender: s.user, RoomID: s.room}
+				if got := l.allow(e); got != s.want {
+					t.Errorf("Step %d: expected %v for %s in %s, got %v", i, s.want, s.user, s.room, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBucketReserve(t *testing.T) {
+	now := time.Now()
+	b := newBucket(&RateLimit{Interval: time.Second, Burst: 2}, now)
+
+	for i := 0; i < 10; i++ {
+		b.reserve(now)
+	}
+	if b.tokens != -2 {
+		t.Errorf("Expected debt to be limited to the burst, got %v tokens", b.tokens)
+	}
+
+	// The debt has to be paid back before a token is available again
+	if b.allow(now.Add(2 * time.Second)) {
+		t.Errorf("Expected no token after 2 seconds")
+	}
+	if !b.allow(now.Add(3 * time.Second)) {
+		t.Errorf("Expected a token after 3 seconds")
+	}
+}
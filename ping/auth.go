@@ -0,0 +1,128 @@
+package ping
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// macPrefix is the prefix of the signature appended to a message.
+const macPrefix = "mac="
+
+// macSize is the number of bytes of the HMAC included in a message.
+const macSize = 16
+
+// MaxAge is the maximum age of signed messages.
+const MaxAge = 5 * time.Minute
+
+var (
+	// ErrMissingSignature is returned when a message is not signed.
+	ErrMissingSignature = errors.New("missing signature")
+
+	// ErrInvalidSignature is returned when a message has an invalid signature.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrReplayed is returned when a signed message is too old, has no timestamp,
+	// or has been received before.
+	ErrReplayed = errors.New("replayed message")
+)
+
+// RejectReason returns the reason for rejecting a message with the given error.
+// This is either "signature" or "replay".
+func RejectReason(err error) string {
+	if errors.Is(err, ErrReplayed) {
+		return "replay"
+	}
+	return "signature"
+}
+
+// Signer signs and verifies messages using a shared secret.
+// A nil Signer does not sign messages, and accepts all messages.
+type Signer struct {
+	key []byte
+
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewSigner returns a Signer for the given secret, or nil if the secret is empty.
+func NewSigner(secret string) *Signer {
+	if secret == "" {
+		return nil
+	}
+
+	return &Signer{key: []byte(secret), seen: make(map[string]time.Time)}
+}
+
+// Sign returns the message with a signature appended.
+func (s *Signer) Sign(msg string) string {
+	msg = strings.TrimSpace(msg)
+	if s == nil {
+		return msg
+	}
+
+	return msg + " " + macPrefix + s.mac(msg)
+}
+
+// Verify checks the signature of a message, and returns the message without the signature.
+// Messages that are older than MaxAge, or that have been verified before, are rejected.
+func (s *Signer) Verify(msg string) (string, error) {
+	msg = strings.TrimSpace(msg)
+	if s == nil {
+		return msg, nil
+	}
+
+	i := strings.LastIndex(msg, " "+macPrefix)
+	if i < 0 {
+		return msg, ErrMissingSignature
+	}
+
+	content, mac := msg[:i], msg[i+len(macPrefix)+1:]
+	if !hmac.Equal([]byte(mac), []byte(s.mac(content))) {
+		return content, ErrInvalidSignature
+	}
+
+	// Check for replays using the kind, ID and timestamp
+	p, err := Parse(content)
+	if err != nil || p.Time.IsZero() || !s.accept(p.Kind+" "+p.ID, p.Time) {
+		return content, ErrReplayed
+	}
+
+	return content, nil
+}
+
+// mac returns the hex encoded HMAC of a message.
+func (s *Signer) mac(msg string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(msg))
+	return hex.EncodeToString(h.Sum(nil)[:macSize])
+}
+
+// accept returns true if a message with the given key and timestamp is recent,
+// and has not been accepted before.
+func (s *Signer) accept(key string, ts time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ts) > MaxAge || ts.Sub(now) > MaxAge {
+		return false
+	}
+
+	for k, t := range s.seen {
+		if now.Sub(t) > MaxAge {
+			delete(s.seen, k)
+		}
+	}
+
+	if _, ok := s.seen[key]; ok {
+		return false
+	}
+
+	s.seen[key] = ts
+	return true
+}
@@ -0,0 +1,295 @@
+package ping
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// PingMessage contains the prefix for a ping message
+	PingMessage = "ping"
+
+	// PingResponse contains the expected response prefix to a ping message
+	PingResponse = "pong"
+)
+
+const (
+	// VersionLegacy is the version of the legacy wire format:
+	//
+	//	ping [id] [unix time in ns]
+	//	pong <id> <unix time in ns> [delay in ns] [human-readable delay]
+	VersionLegacy = 1
+
+	// Version2 is the version of the structured wire format:
+	//
+	//	ping/2 id=<id> ts=<unix time in ns> [src=<sender>]
+	//	pong/2 id=<id> ts=<unix time in ns> [pts=<ping time>] [rx=<ping received time>] [irc=<ping IRC server time>] [src=<sender>]
+	//
+	// Unknown fields are ignored.
+	Version2 = 2
+
+	// Version is the default version of the wire format.
+	Version = Version2
+)
+
+// legacyID is the ID used in replies to legacy pings without an ID.
+const legacyID = "unixnano"
+
+var (
+	// ErrUnknownKind is returned when a message is not a ping or a ping reply.
+	ErrUnknownKind = errors.New("not a ping message")
+
+	// ErrUnsupportedVersion is returned for messages with an unsupported version.
+	ErrUnsupportedVersion = errors.New("unsupported version")
+
+	// ErrMissingField is returned when a required field is missing.
+	ErrMissingField = errors.New("missing field")
+)
+
+// Packet is the wire representation of a ping or ping reply.
+type Packet struct {
+	// Kind is either PingMessage or PingResponse.
+	Kind string
+
+	// Version is the version of the wire format.
+	Version int
+
+	// ID is the ID of the ping.
+	ID string
+
+	// Time is the time the packet was sent.
+	Time time.Time
+
+	// Source is the sender of the packet, if known.
+	Source string
+
+	// PingTime is the time the ping was sent, for ping replies.
+	PingTime time.Time
+
+	// Received is the time the ping was received by the responder, for ping replies.
+	Received time.Time
+
+	// PingIRC is the time the ping was stamped by the IRC server, for ping replies.
+	// This is only known if the IRC server supports the IRCv3 server-time capability.
+	PingIRC time.Time
+
+	// Delay is the delay reported in a legacy ping reply.
+	Delay time.Duration
+}
+
+// IsPacket returns true if a message looks like a ping or ping reply.
+func IsPacket(msg string) bool {
+	kind, _, _ := strings.Cut(firstField(msg), "/")
+	return kind == PingMessage || kind == PingResponse
+}
+
+// IsResponse returns true if a message looks like a ping reply.
+func IsResponse(msg string) bool {
+	kind, _, _ := strings.Cut(firstField(msg), "/")
+	return kind == PingResponse
+}
+
+// Parse decodes a ping or ping reply in any supported version.
+func Parse(msg string) (*Packet, error) {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return nil, ErrUnknownKind
+	}
+
+	kind, version, versioned := strings.Cut(fields[0], "/")
+	if kind != PingMessage && kind != PingResponse {
+		return nil, ErrUnknownKind
+	}
+
+	if !versioned {
+		return parseLegacy(kind, fields[1:])
+	}
+
+	v, err := strconv.Atoi(version)
+	if err != nil || v < Version2 {
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedVersion, version)
+	}
+
+	return parseFields(kind, v, fields[1:])
+}
+
+// parseLegacy decodes the fields of a legacy ping or ping reply.
+// Invalid timestamps in pings are ignored, as legacy responders did.
+// Any additional fields are ignored.
+func parseLegacy(kind string, fields []string) (*Packet, error) {
+	p := &Packet{Kind: kind, Version: VersionLegacy}
+
+	if len(fields) >= 1 {
+		p.ID = fields[0]
+	}
+
+	if len(fields) >= 2 {
+		ts, err := strconv.ParseInt(fields[1], 0, 64)
+		switch {
+		case err == nil:
+			p.Time = time.Unix(0, ts)
+		case kind == PingResponse:
+			return nil, fmt.Errorf("invalid timestamp %q: %w", fields[1], err)
+		}
+	}
+
+	if kind == PingResponse {
+		// Legacy responders reply to structured pings with the raw ID field
+		p.ID = strings.TrimPrefix(p.ID, "id=")
+		if strings.HasPrefix(p.ID, "id=") {
+			return nil, fmt.Errorf("invalid id %q", p.ID)
+		}
+
+		if p.ID == "" || p.Time.IsZero() {
+			return nil, fmt.Errorf("%w: id or timestamp", ErrMissingField)
+		}
+
+		// The delay is optional, and followed by arbitrary text
+		if len(fields) >= 3 {
+			if d, err := strconv.ParseInt(fields[2], 0, 64); err == nil {
+				p.Delay = time.Duration(d)
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// parseFields decodes the key-value fields of a structured ping or ping reply.
+func parseFields(kind string, version int, fields []string) (p *Packet, err error) {
+	p = &Packet{Kind: kind, Version: version}
+
+	for _, f := range fields {
+		key, value, ok := strings.Cut(f, "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "id":
+			p.ID = value
+		case "src":
+			p.Source = value
+		case "ts":
+			p.Time, err = parseTime(key, value)
+		case "pts":
+			p.PingTime, err = parseTime(key, value)
+		case "rx":
+			p.Received, err = parseTime(key, value)
+		case "irc":
+			p.PingIRC, err = parseTime(key, value)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.ID == "":
+		return nil, fmt.Errorf("%w: id", ErrMissingField)
+	case p.Time.IsZero():
+		return nil, fmt.Errorf("%w: ts", ErrMissingField)
+	}
+
+	return p, nil
+}
+
+// parseTime parses a timestamp field in Unix nanoseconds.
+func parseTime(key, value string) (time.Time, error) {
+	ts, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: %w", key, value, err)
+	}
+
+	return time.Unix(0, ts), nil
+}
+
+// String encodes the packet in its version of the wire format.
+func (p *Packet) String() string {
+	if p.Version <= VersionLegacy {
+		return p.legacyString()
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s/%d id=%s ts=%d", p.Kind, p.Version, p.ID, p.Time.UnixNano())
+	if !p.PingTime.IsZero() {
+		fmt.Fprintf(&b, " pts=%d", p.PingTime.UnixNano())
+	}
+	if !p.Received.IsZero() {
+		fmt.Fprintf(&b, " rx=%d", p.Received.UnixNano())
+	}
+	if !p.PingIRC.IsZero() {
+		fmt.Fprintf(&b, " irc=%d", p.PingIRC.UnixNano())
+	}
+	if p.Source != "" {
+		fmt.Fprintf(&b, " src=%s", p.Source)
+	}
+
+	return b.String()
+}
+
+// legacyString encodes the packet in the legacy wire format.
+func (p *Packet) legacyString() string {
+	parts := []string{p.Kind}
+	if p.ID != "" {
+		parts = append(parts, p.ID)
+	}
+	if !p.Time.IsZero() {
+		parts = append(parts, strconv.FormatInt(p.Time.UnixNano(), 10))
+	}
+	if p.Kind == PingResponse && p.Delay != 0 {
+		parts = append(parts, strconv.FormatInt(int64(p.Delay), 10), p.Delay.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Reply returns the reply to a ping, in the same version of the wire format.
+// The reply contains the time the ping was received, and the time the reply is sent.
+func (p *Packet) Reply(received, sent time.Time, source string) *Packet {
+	r := &Packet{Kind: PingResponse, Version: p.Version, ID: p.ID, Time: sent}
+
+	if p.Version <= VersionLegacy {
+		// Legacy ping replies are parsed with any `id=` prefix removed,
+		// so IDs that would be empty or invalid after that are replaced.
+		id := strings.TrimPrefix(r.ID, "id=")
+		if id == "" || strings.HasPrefix(id, "id=") {
+			r.ID = legacyID
+		}
+		if !p.Time.IsZero() {
+			r.Delay = sent.Sub(p.Time)
+		}
+		return r
+	}
+
+	r.PingTime = p.Time
+	r.Received = received
+	r.Source = source
+
+	return r
+}
+
+// Message converts the packet to a Message in the given room.
+func (p *Packet) Message(room string) *Message {
+	return &Message{
+		Kind:         p.Kind,
+		ID:           p.ID,
+		Room:         room,
+		Sent:         p.Time,
+		PingReceived: p.Received,
+		PingIRC:      p.PingIRC,
+	}
+}
+
+// firstField returns the first whitespace separated field of a message.
+func firstField(msg string) string {
+	fields := strings.Fields(msg)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
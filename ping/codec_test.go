@@ -0,0 +1,57 @@
+package ping
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func FuzzParse(f *testing.F) {
+	f.Add("ping")
+	f.Add("ping abc")
+	f.Add("ping abc 1700000000000000000")
+	f.Add("ping abc notatime")
+	f.Add("pong abc 1700000000000000000")
+	f.Add("pong abc 1700000000000000000 1500000 1.5ms")
+	f.Add("pong id=abc 1700000000000000000 ")
+	f.Add("ping/2 id=abc ts=1700000000000000000 src=@bot:example.com")
+	f.Add("pong/2 id=abc ts=1700000000000000000 pts=1699999999000000000 rx=1699999999500000000 src=PingBot")
+	f.Add("pong/2 id=abc ts=1700000000000000000 pts=1699999999000000000 rx=1699999999500000000 irc=1699999999400000000 src=PingBot")
+	f.Add("pong/2 id=abc ts=1700000000000000000 mac=0123456789abcdef")
+	f.Add("ping/3 id=abc ts=1 future=field")
+	f.Add("ping/1 id=abc ts=1")
+	f.Add("pong/2 id= ts=1")
+	f.Add("pong id=id=abc 1")
+	f.Add("ping id=")
+	f.Add("ping id=id=abc 1")
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		p, err := Parse(msg)
+		if err != nil {
+			return
+		}
+
+		// The encoded packet must decode to the same packet
+		encoded := p.String()
+		q, err := Parse(encoded)
+		if err != nil {
+			t.Fatalf("Parse(%q) of encoded %q: %s", encoded, msg, err)
+		}
+		if !reflect.DeepEqual(p, q) {
+			t.Fatalf("Parse(%q) = %#v, expected %#v", encoded, q, p)
+		}
+
+		// Replies to pings must be valid ping replies
+		if p.Kind == PingMessage {
+			now := time.Now()
+			encoded = p.Reply(now, now, "responder").String()
+			r, err := Parse(encoded)
+			if err != nil {
+				t.Fatalf("Parse(%q) of reply to %q: %s", encoded, msg, err)
+			}
+			if r.Kind != PingResponse || r.Version != p.Version {
+				t.Fatalf("Parse(%q) = %#v, expected a reply to %#v", encoded, r, p)
+			}
+		}
+	})
+}
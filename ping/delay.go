@@ -7,8 +7,26 @@ import (
 // Message represents a ping or pong message.
 // It is sent from a client, arrives on matrix, and is received by another client.
 type Message struct {
-	Kind, Room, ID         string
-	Sent, Matrix, Received time.Time
+	Kind     string    `json:"kind"`
+	Room     string    `json:"room"`
+	ID       string    `json:"id"`
+	Sent     time.Time `json:"sent"`
+	Matrix   time.Time `json:"matrix"`
+	Received time.Time `json:"received"`
+
+	// Sender is the user that sent the message, as seen by the receiver.
+	Sender string `json:"sender,omitempty"`
+
+	// IRC is the time the message was stamped by the IRC server, if known.
+	IRC time.Time `json:"irc"`
+
+	// PingReceived is the time the ping was received by the responder,
+	// for ping replies that contain it.
+	PingReceived time.Time `json:"ping_received"`
+
+	// PingIRC is the time the ping was stamped by the IRC server,
+	// for ping replies that contain it.
+	PingIRC time.Time `json:"ping_irc"`
 }
 
 // ToMatrix returns the delay from the sender to matrix.
@@ -21,6 +39,24 @@ func (m *Message) FromMatrix() time.Duration {
 	return m.Received.Sub(m.Matrix)
 }
 
+// ToIRC returns the delay from matrix to the IRC server.
+// It returns zero if the IRC server time is unknown.
+func (m *Message) ToIRC() time.Duration {
+	if m.IRC.IsZero() {
+		return 0
+	}
+	return m.IRC.Sub(m.Matrix)
+}
+
+// FromIRC returns the delay from the IRC server to the receiver.
+// It returns zero if the IRC server time is unknown.
+func (m *Message) FromIRC() time.Duration {
+	if m.IRC.IsZero() {
+		return 0
+	}
+	return m.Received.Sub(m.IRC)
+}
+
 // Total returns the total delay from the sender to the receiver.
 func (m *Message) Total() time.Duration {
 	return m.Received.Sub(m.Sent)
@@ -28,10 +64,39 @@ func (m *Message) Total() time.Duration {
 
 // Delay represents a delay for a room.
 type Delay struct {
-	Ping, Pong *Message
+	Ping *Message `json:"ping"`
+	Pong *Message `json:"pong"`
 }
 
 // RTT returns the delay between the sending of the ping, and the reception of the ping reply.
 func (d *Delay) RTT() time.Duration {
 	return d.Pong.Received.Sub(d.Ping.Sent)
 }
+
+// ClockOffset estimates the offset of the clock of the responder,
+// relative to the clock of the sender of the ping.
+// The estimate uses the four timestamps of the ping and ping reply, like NTP,
+// and assumes that the delay in both directions is the same.
+// If the ping reply does not contain the time the ping was received,
+// the time the reply was sent is used instead.
+func (d *Delay) ClockOffset() time.Duration {
+	t1 := d.Ping.Sent
+	t2 := d.Pong.PingReceived
+	t3 := d.Pong.Sent
+	t4 := d.Pong.Received
+
+	if t2.IsZero() {
+		t2 = t3
+	}
+
+	return (t2.Sub(t1) + t3.Sub(t4)) / 2
+}
+
+// CorrectClockOffset corrects the timestamps set by the responder for a clock offset.
+func (d *Delay) CorrectClockOffset(offset time.Duration) {
+	d.Ping.Received = d.Ping.Received.Add(-offset)
+	d.Pong.Sent = d.Pong.Sent.Add(-offset)
+	if !d.Pong.PingReceived.IsZero() {
+		d.Pong.PingReceived = d.Pong.PingReceived.Add(-offset)
+	}
+}
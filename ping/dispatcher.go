@@ -0,0 +1,74 @@
+package ping
+
+import (
+	"sync"
+)
+
+// Dispatcher routes incoming messages to the probe that owns their ID.
+type Dispatcher struct {
+	mu     sync.Mutex
+	owners map[string]chan<- *Message
+
+	// Unclaimed is called for messages without an owner, if set.
+	Unclaimed func(msg *Message)
+}
+
+// NewDispatcher returns an empty Dispatcher.
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{owners: make(map[string]chan<- *Message)}
+}
+
+// Register routes all messages with the given ID to a channel.
+// Messages are dropped if the channel is full.
+func (d *Dispatcher) Register(id string, ch chan<- *Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.owners[id] = ch
+}
+
+// Unregister stops routing messages with the given ID.
+func (d *Dispatcher) Unregister(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.owners, id)
+}
+
+// Dispatch sends a message to its owner.
+// It returns false if the message has no owner, or if it could not be delivered.
+func (d *Dispatcher) Dispatch(msg *Message) bool {
+	d.mu.Lock()
+	ch, ok := d.owners[msg.ID]
+	d.mu.Unlock()
+
+	if !ok {
+		if d.Unclaimed != nil {
+			d.Unclaimed(msg)
+		}
+		return false
+	}
+
+	select {
+	case ch <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run dispatches all messages received on the given channels.
+// It returns when all channels are closed.
+func (d *Dispatcher) Run(channels ...<-chan *Message) {
+	var wg sync.WaitGroup
+	for _, ch := range channels {
+		wg.Add(1)
+		go func(ch <-chan *Message) {
+			defer wg.Done()
+			for msg := range ch {
+				d.Dispatch(msg)
+			}
+		}(ch)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,53 @@
+package ping
+
+import (
+	"time"
+)
+
+// Reason describes why a probe failed.
+type Reason string
+
+const (
+	// ReasonSendFailed indicates that the ping could not be sent.
+	ReasonSendFailed Reason = "send_failed"
+
+	// ReasonPingLost indicates that the ping was never received back through Matrix.
+	ReasonPingLost Reason = "ping_lost"
+
+	// ReasonPongLost indicates that the ping reply was not received in time.
+	ReasonPongLost Reason = "pong_lost"
+
+	// ReasonPongLate indicates that the ping reply was received after the probe timed out.
+	ReasonPongLate Reason = "pong_late"
+
+	// ReasonPongMalformed indicates that an invalid ping reply was received.
+	ReasonPongMalformed Reason = "pong_malformed"
+
+	// ReasonPongUnexpected indicates that a ping reply was received from an unexpected responder.
+	ReasonPongUnexpected Reason = "pong_unexpected"
+)
+
+// Reasons contains all failure reasons.
+var Reasons = []Reason{
+	ReasonSendFailed, ReasonPingLost, ReasonPongLost,
+	ReasonPongLate, ReasonPongMalformed, ReasonPongUnexpected,
+}
+
+// Result represents the outcome of a single probe of a network.
+type Result struct {
+	Network string    `json:"network"`
+	ID      string    `json:"id,omitempty"`
+	Time    time.Time `json:"time"`
+	Delay   *Delay    `json:"delay"`
+
+	// Reason is the reason the probe failed, and empty on success.
+	Reason Reason `json:"reason,omitempty"`
+
+	// ClockOffset is the estimated clock offset of the responder, for successful probes.
+	ClockOffset time.Duration `json:"clock_offset"`
+}
+
+// Success returns true if both the ping and the ping reply have been received.
+func (r *Result) Success() bool {
+	return r.Reason == "" && r.Delay != nil && r.Delay.Ping != nil && r.Delay.Pong != nil
+}
@@ -0,0 +1,131 @@
+package ping
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultWindow is the default number of probes in the window of a Stats.
+const DefaultWindow = 20
+
+// Stats contains rolling statistics over the last probes of a network.
+// It is safe for concurrent use.
+type Stats struct {
+	mu      sync.Mutex
+	window  int
+	results []*Result
+
+	lastSuccess, lastFailure time.Time
+}
+
+// Summary contains the statistics of the probes in a window.
+// The round trip time statistics only include successful probes.
+type Summary struct {
+	// Count is the number of probes in the window.
+	Count int
+
+	// Lost is the number of failed probes in the window.
+	Lost int
+
+	Min, Avg, Max, StdDev time.Duration
+
+	// Jitter is the interarrival jitter of the round trip time,
+	// estimated as specified in RFC 3550, section 6.4.1.
+	Jitter time.Duration
+
+	// LastSuccess and LastFailure are the times of the last successful
+	// and failed probe, including those outside the window.
+	LastSuccess, LastFailure time.Time
+}
+
+// NewStats returns empty statistics over a window of the given number of probes.
+// DefaultWindow is used if the window is not positive.
+func NewStats(window int) *Stats {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	return &Stats{window: window, results: make([]*Result, 0, window)}
+}
+
+// Add adds the result of a probe, removing the oldest probe if the window is full.
+func (s *Stats) Add(r *Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.results) == s.window {
+		copy(s.results, s.results[1:])
+		s.results = s.results[:len(s.results)-1]
+	}
+
+	s.results = append(s.results, r)
+
+	if r.Success() {
+		s.lastSuccess = r.Time
+	} else {
+		s.lastFailure = r.Time
+	}
+}
+
+// Results returns the results of the probes in the window, oldest first.
+func (s *Stats) Results() []*Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Result(nil), s.results...)
+}
+
+// Summary returns the statistics of the probes in the window.
+func (s *Stats) Summary() *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sum := &Summary{Count: len(s.results), LastSuccess: s.lastSuccess, LastFailure: s.lastFailure}
+
+	var n int
+	var total, squares, jitter float64
+	var prev time.Duration
+	for _, r := range s.results {
+		if !r.Success() {
+			sum.Lost++
+			continue
+		}
+
+		rtt := r.Delay.RTT()
+		if n == 0 || rtt < sum.Min {
+			sum.Min = rtt
+		}
+		if n == 0 || rtt > sum.Max {
+			sum.Max = rtt
+		}
+
+		// J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16
+		if n > 0 {
+			jitter += (math.Abs(float64(rtt-prev)) - jitter) / 16
+		}
+
+		n++
+		prev = rtt
+		total += float64(rtt)
+		squares += float64(rtt) * float64(rtt)
+	}
+
+	if n > 0 {
+		avg := total / float64(n)
+		sum.Avg = time.Duration(avg)
+		sum.StdDev = time.Duration(math.Sqrt(math.Max(squares/float64(n)-avg*avg, 0)))
+		sum.Jitter = time.Duration(jitter)
+	}
+
+	return sum
+}
+
+// Loss returns the fraction of failed probes in the window.
+func (s *Summary) Loss() float64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	return float64(s.Lost) / float64(s.Count)
+}
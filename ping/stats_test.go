@@ -0,0 +1,100 @@
+package ping
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStatsSummary(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Minute) }
+	ms := time.Millisecond
+
+	// A zero round trip time is a failed probe
+	tests := []struct {
+		name   string
+		window int
+		rtts   []time.Duration
+		want   Summary
+		loss   float64
+	}{
+		{
+			name: "empty",
+			want: Summary{},
+		},
+		{
+			name: "all failed",
+			rtts: []time.Duration{0, 0, 0},
+			want: Summary{Count: 3, Lost: 3, LastFailure: at(2)},
+			loss: 1,
+		},
+		{
+			name: "known sequence",
+			rtts: []time.Duration{100 * ms, 300 * ms, 100 * ms, 300 * ms},
+			want: Summary{
+				Count:       4,
+				Min:         100 * ms,
+				Avg:         200 * ms,
+				Max:         300 * ms,
+				StdDev:      100 * ms,
+				Jitter:      35205078, // 12.5ms, 24.21875ms, 35.205078125ms
+				LastSuccess: at(3),
+			},
+		},
+		{
+			name: "failures do not affect jitter",
+			rtts: []time.Duration{100 * ms, 0, 300 * ms},
+			want: Summary{
+				Count:       3,
+				Lost:        1,
+				Min:         100 * ms,
+				Avg:         200 * ms,
+				Max:         300 * ms,
+				StdDev:      100 * ms,
+				Jitter:      12500 * time.Microsecond,
+				LastSuccess: at(2),
+				LastFailure: at(1),
+			},
+			loss: 1.0 / 3,
+		},
+		{
+			name:   "window rollover",
+			window: 3,
+			rtts:   []time.Duration{0, 0, 200 * ms, 200 * ms, 200 * ms},
+			want: Summary{
+				Count:       3,
+				Min:         200 * ms,
+				Avg:         200 * ms,
+				Max:         200 * ms,
+				LastSuccess: at(4),
+				LastFailure: at(1),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewStats(test.window)
+			for i, rtt := range test.rtts {
+				r := &Result{Network: "test", Time: at(i), Reason: ReasonPongLost}
+				if rtt > 0 {
+					r.Reason = ""
+					r.Delay = &Delay{Ping: &Message{Sent: at(i)}, Pong: &Message{Received: at(i).Add(rtt)}}
+				}
+				s.Add(r)
+			}
+
+			if n := len(s.Results()); n != test.want.Count {
+				t.Errorf("Expected %d results, got %d", test.want.Count, n)
+			}
+
+			sum := s.Summary()
+			if *sum != test.want {
+				t.Errorf("Expected summary %+v, got %+v", test.want, *sum)
+			}
+			if loss := sum.Loss(); loss != test.loss {
+				t.Errorf("Expected loss %v, got %v", test.loss, loss)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+// apiNetworksPath is the path of the networks API endpoint.
+const apiNetworksPath = "/api/v1/networks"
+
+// apiResult is the result of the latest probe of a network, as returned by the API.
+// All fields except the network are omitted if the network has not been probed yet.
+type apiResult struct {
+	Network     string             `json:"network"`
+	ID          string             `json:"id,omitempty"`
+	Time        *time.Time         `json:"time,omitempty"`
+	Success     *bool              `json:"success,omitempty"`
+	Reason      ping.Reason        `json:"reason,omitempty"`
+	ClockOffset *float64           `json:"clock_offset_seconds,omitempty"`
+	Hops        map[string]float64 `json:"hops_seconds,omitempty"`
+	Delay       *ping.Delay        `json:"delay,omitempty"`
+}
+
+// newAPIResult returns the API representation of the result of a network.
+// The result may be nil.
+func newAPIResult(network string, r *ping.Result) *apiResult {
+	a := &apiResult{Network: network}
+	if r == nil {
+		return a
+	}
+
+	success := r.Success()
+	a.ID = r.ID
+	a.Time = &r.Time
+	a.Success = &success
+	a.Reason = r.Reason
+	a.Delay = r.Delay
+	a.Hops = hops(r.Delay)
+
+	if success {
+		offset := r.ClockOffset.Seconds()
+		a.ClockOffset = &offset
+	}
+
+	return a
+}
+
+// hops returns the durations of all known hops of a delay in seconds, by path.
+// The paths are the same as those of the delay histogram.
+func hops(d *ping.Delay) map[string]float64 {
+	if d == nil || d.Ping == nil {
+		return nil
+	}
+
+	h := map[string]float64{
+		"ping_matrix": d.Ping.ToMatrix().Seconds(),
+	}
+
+	if d.Pong == nil {
+		return h
+	}
+
+	h["ping"] = d.Ping.Total().Seconds()
+	h["ping_irc"] = d.Ping.FromMatrix().Seconds()
+	if !d.Ping.IRC.IsZero() {
+		h["ping_bridge"] = d.Ping.ToIRC().Seconds()
+		h["ping_ircd"] = d.Ping.FromIRC().Seconds()
+	}
+	h["pong"] = d.Pong.Total().Seconds()
+	h["pong_matrix"] = d.Pong.FromMatrix().Seconds()
+	h["pong_irc"] = d.Pong.ToMatrix().Seconds()
+	h["rtt"] = d.RTT().Seconds()
+
+	return h
+}
+
+// APIHandler is an HTTP handler that returns the results of the latest background probes as JSON.
+// It serves the list of all networks on `/api/v1/networks`,
+// and a single network on `/api/v1/networks/<name>`.
+func (e *Exporter) APIHandler(w http.ResponseWriter, r *http.Request) {
+	name, single := strings.CutPrefix(r.URL.Path, apiNetworksPath+"/")
+	if !single && r.URL.Path != apiNetworksPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	slog.Debug("Handling API request", "path", r.URL.Path)
+
+	// Copy the results, so the lock is not held while writing to the client
+	latest := e.Results()
+
+	if single {
+		r, ok := latest[name]
+		if !ok {
+			writeJSONError(w, "unknown network: "+strconv.Quote(name), http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, newAPIResult(name, r))
+		return
+	}
+
+	results := make([]*apiResult, 0, len(latest))
+	for n, r := range latest {
+		results = append(results, newAPIResult(n, r))
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Network < results[j].Network
+	})
+
+	writeJSON(w, results)
+}
+
+// writeJSON writes a value as a JSON response.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing JSON response", "err", err)
+	}
+}
+
+// writeJSONError writes an error as a JSON response with the given status code.
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,48 @@
+package prometheus
+
+import (
+	"strings"
+	"time"
+)
+
+// Probe ties a Matrix room to the IRC network and channel it is bridged to.
+type Probe struct {
+	// IRC is the name of the IRC network.
+	IRC string
+
+	// Channel is the IRC channel that is bridged to the Matrix room.
+	Channel string
+
+	// Responder is the IRC nick or Matrix user ID of the bot that replies to pings.
+	// Replies from other users are ignored if this is set.
+	// An IRC nick requires Puppet to be set.
+	Responder string
+
+	// Puppet is the Matrix user ID of bridged IRC users, with `{nick}` in place of the nick,
+	// such as `@irc_{nick}:example.com`.
+	Puppet string
+
+	// Interval is the interval between background probes of the room.
+	// Defaults to the interval of the exporter.
+	Interval time.Duration
+}
+
+// MatchResponder checks if a Matrix user ID belongs to the responder.
+// An IRC nick only matches the Matrix user ID given by the puppet pattern,
+// and never matches if no puppet pattern is configured.
+func (p *Probe) MatchResponder(sender string) bool {
+	if strings.HasPrefix(p.Responder, "@") {
+		return sender == p.Responder
+	}
+
+	if p.Puppet == "" {
+		return false
+	}
+
+	return strings.EqualFold(sender, p.PuppetID(p.Responder))
+}
+
+// PuppetID returns the Matrix user ID of the bridged IRC user with the given nick.
+func (p *Probe) PuppetID(nick string) string {
+	return strings.ReplaceAll(p.Puppet, "{nick}", nick)
+}
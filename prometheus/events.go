@@ -0,0 +1,138 @@
+package prometheus
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+const (
+	// eventBuffer is the number of events buffered for every subscriber.
+	// Events are dropped for subscribers that are too slow.
+	eventBuffer = 64
+
+	// eventKeepAlive is the interval of keep-alive comments in the event stream.
+	eventKeepAlive = 30 * time.Second
+)
+
+// liveEvent is a ping or ping reply arriving at a hop of a running probe.
+type liveEvent struct {
+	Network string    `json:"network"`
+	ID      string    `json:"id"`
+	Kind    string    `json:"kind"`
+	Hop     string    `json:"hop"`
+	Time    time.Time `json:"time"`
+	Delay   float64   `json:"delay_seconds"`
+	Sender  string    `json:"sender,omitempty"`
+}
+
+// broker distributes live events to all subscribers.
+type broker struct {
+	mu   sync.Mutex
+	subs map[chan *liveEvent]struct{}
+}
+
+// newBroker returns a broker without subscribers.
+func newBroker() *broker {
+	return &broker{subs: make(map[chan *liveEvent]struct{})}
+}
+
+// subscribe returns a channel that receives all published events.
+func (b *broker) subscribe() chan *liveEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan *liveEvent, eventBuffer)
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops sending events to a channel returned by subscribe.
+func (b *broker) unsubscribe(ch chan *liveEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, ch)
+}
+
+// publish sends an event for a message arriving at a hop to all subscribers, without blocking.
+func (b *broker) publish(network, hop string, msg *ping.Message, delay time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.subs) == 0 {
+		return
+	}
+
+	ev := &liveEvent{
+		Network: network,
+		ID:      msg.ID,
+		Kind:    msg.Kind,
+		Hop:     hop,
+		Time:    time.Now(),
+		Delay:   delay.Seconds(),
+		Sender:  msg.Sender,
+	}
+
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			slog.Debug("Dropping live event", "network", network, "ping_id", msg.ID, "hop", hop)
+		}
+	}
+}
+
+// EventsHandler is an HTTP handler that streams the pings and ping replies
+// of all running probes as server-sent events.
+// Every event contains the network, probe ID, hop and the delay of that hop.
+func (e *Exporter) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Debug("Handling events request", "remote", r.RemoteAddr)
+
+	ch := e.events.subscribe()
+	defer e.events.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(eventKeepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case ev := <-ch:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				slog.Error("Error encoding live event", "err", err)
+				continue
+			}
+
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Hop, data)
+			if err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		flusher.Flush()
+	}
+}
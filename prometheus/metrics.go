@@ -0,0 +1,97 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+// DefaultBuckets contains the default buckets for the delay histograms, in seconds.
+var DefaultBuckets = []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// metrics contains the cumulative metrics of all probes.
+type metrics struct {
+	delays   *prometheus.HistogramVec
+	sent     *prometheus.CounterVec
+	received *prometheus.CounterVec
+	timeouts *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	rejected *prometheus.CounterVec
+}
+
+// newMetrics returns the cumulative metrics for the given networks.
+func newMetrics(networks []string, buckets []float64) *metrics {
+	m := &metrics{
+		delays: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "matrix_irc_delay_seconds",
+			Help:    "Histogram of the delays of all probes, by path.",
+			Buckets: buckets,
+		}, []string{"network", "path"}),
+		sent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "matrix_irc_pings_sent_total",
+			Help: "Number of pings sent.",
+		}, []string{"network"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "matrix_irc_pongs_received_total",
+			Help: "Number of ping replies received in time.",
+		}, []string{"network"}),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "matrix_irc_ping_timeouts_total",
+			Help: "Number of probes that timed out.",
+		}, []string{"network"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "matrix_irc_ping_failures_total",
+			Help: "Number of failed pings, by reason.",
+		}, []string{"network", "reason"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "matrix_irc_messages_rejected_total",
+			Help: "Number of rejected ping messages with an invalid signature or that were replayed, by reason.",
+		}, []string{"network", "reason"}),
+	}
+
+	// Initialise counters, so they are present before the first probe.
+	for _, n := range networks {
+		m.sent.WithLabelValues(n)
+		m.received.WithLabelValues(n)
+		m.timeouts.WithLabelValues(n)
+		for _, r := range ping.Reasons {
+			m.failures.WithLabelValues(n, string(r))
+		}
+		m.rejected.WithLabelValues(n, "signature")
+		m.rejected.WithLabelValues(n, "replay")
+	}
+
+	return m
+}
+
+// collectors returns all collectors for registration.
+func (m *metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.delays, m.sent, m.received, m.timeouts, m.failures, m.rejected}
+}
+
+// observe adds the result of a probe to the metrics.
+func (m *metrics) observe(r *ping.Result) {
+	switch r.Reason {
+	case "":
+	case ping.ReasonPingLost, ping.ReasonPongLost:
+		m.timeouts.WithLabelValues(r.Network).Inc()
+		fallthrough
+	default:
+		m.failures.WithLabelValues(r.Network, string(r.Reason)).Inc()
+		return
+	}
+
+	d := r.Delay
+	m.received.WithLabelValues(r.Network).Inc()
+	m.delays.WithLabelValues(r.Network, "ping").Observe(d.Ping.Total().Seconds())
+	m.delays.WithLabelValues(r.Network, "ping_matrix").Observe(d.Ping.ToMatrix().Seconds())
+	m.delays.WithLabelValues(r.Network, "ping_irc").Observe(d.Ping.FromMatrix().Seconds())
+	if !d.Ping.IRC.IsZero() {
+		m.delays.WithLabelValues(r.Network, "ping_bridge").Observe(d.Ping.ToIRC().Seconds())
+		m.delays.WithLabelValues(r.Network, "ping_ircd").Observe(d.Ping.FromIRC().Seconds())
+	}
+	m.delays.WithLabelValues(r.Network, "pong").Observe(d.Pong.Total().Seconds())
+	m.delays.WithLabelValues(r.Network, "pong_matrix").Observe(d.Pong.FromMatrix().Seconds())
+	m.delays.WithLabelValues(r.Network, "pong_irc").Observe(d.Pong.ToMatrix().Seconds())
+	m.delays.WithLabelValues(r.Network, "rtt").Observe(d.RTT().Seconds())
+}
@@ -0,0 +1,352 @@
+package prometheus
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"maunium.net/go/mautrix/id"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+	"github.com/silkeh/matrix_irc_ping_exporter/util"
+)
+
+// lateExpiry is the duration after which a ping reply for a timed out probe
+// is no longer counted as late.
+const lateExpiry = time.Hour
+
+// Run runs never ending probe loops, probing every room on its own interval.
+// Reverse probes from IRC are run every interval of the exporter.
+func (e *Exporter) Run() {
+	for n, roomID := range e.Rooms {
+		go e.runRoom(n, roomID, e.interval(n))
+	}
+
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+		e.reverseProbes(ctx)
+		cancel()
+
+		<-ticker.C
+	}
+}
+
+// runRoom runs a never ending probe loop for a single room.
+func (e *Exporter) runRoom(network string, roomID id.RoomID, interval time.Duration) {
+	slog.Debug("Starting probe loop", "network", network, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	rooms := map[string]id.RoomID{network: roomID}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), e.Timeout)
+		results := e.Probe(ctx, rooms)
+		cancel()
+
+		e.mu.Lock()
+		for n, r := range results {
+			e.results[n] = r
+		}
+		e.mu.Unlock()
+
+		for n, r := range results {
+			e.stats[n].Add(r)
+			if e.OnResult != nil {
+				e.OnResult(r)
+			}
+		}
+
+		<-ticker.C
+	}
+}
+
+// interval returns the interval between background probes of a network.
+func (e *Exporter) interval(network string) time.Duration {
+	if p, ok := e.Probes[network]; ok && p.Interval > 0 {
+		return p.Interval
+	}
+	return e.Interval
+}
+
+// Probe sends a ping to the given rooms, and returns the results.
+// The probe ends when all replies have been received, or when the context is done.
+func (e *Exporter) Probe(ctx context.Context, rooms map[string]id.RoomID) map[string]*ping.Result {
+	start := time.Now()
+	replies := make(chan *ping.Message, 2*len(rooms))
+
+	// Send ping to all rooms
+	sent := e.sendPings(ctx, rooms, replies)
+
+	slog.Debug("Pings sent, getting delays")
+
+	// Read all delays
+	delays := e.getDelays(ctx, rooms, sent, replies)
+
+	slog.Debug("Got delays")
+
+	results := make(map[string]*ping.Result, len(delays))
+	for n, d := range delays {
+		r := &ping.Result{Network: n, Time: start, Delay: d}
+
+		switch msg, ok := sent[n]; {
+		case !ok:
+			r.Reason = ping.ReasonSendFailed
+		case d.Ping == nil:
+			r.ID = msg.ID
+			r.Reason = ping.ReasonPingLost
+		case d.Pong == nil:
+			r.ID = msg.ID
+			r.Reason = ping.ReasonPongLost
+		default:
+			r.ID = msg.ID
+		}
+
+		if r.Reason == ping.ReasonPingLost || r.Reason == ping.ReasonPongLost {
+			e.expire(r)
+		}
+		if r.ID != "" {
+			e.dispatcher.Unregister(r.ID)
+		}
+
+		normalizeDelay(d)
+		if r.Success() {
+			r.ClockOffset = d.ClockOffset()
+			if e.CorrectClockOffset {
+				d.CorrectClockOffset(r.ClockOffset)
+			}
+		}
+
+		results[n] = r
+		e.metrics.observe(r)
+		e.store(r)
+	}
+
+	return results
+}
+
+// store adds the result of a probe to the history, if enabled.
+func (e *Exporter) store(r *ping.Result) {
+	if e.History == nil {
+		return
+	}
+
+	if err := e.History.Add(r); err != nil {
+		slog.Error("Error storing probe result", "network", r.Network, "err", err)
+	}
+}
+
+// validResponder checks if a ping reply was sent by the responder configured for the network.
+func (e *Exporter) validResponder(msg *ping.Message) bool {
+	p, ok := e.Probes[msg.Room]
+	if !ok || p.Responder == "" {
+		return true
+	}
+
+	return p.MatchResponder(msg.Sender)
+}
+
+// expire registers the ID of a timed out probe, so a late ping reply can be recognised.
+func (e *Exporter) expire(r *ping.Result) {
+	e.lateMu.Lock()
+	defer e.lateMu.Unlock()
+
+	now := time.Now()
+	for i, t := range e.late {
+		if now.Sub(t.Time) > lateExpiry {
+			delete(e.late, i)
+		}
+	}
+
+	e.late[r.ID] = r
+}
+
+// isLate checks if a ping reply belongs to a timed out probe.
+// Every timed out probe is only reported once.
+func (e *Exporter) isLate(msg *ping.Message) bool {
+	e.lateMu.Lock()
+	defer e.lateMu.Unlock()
+
+	if _, ok := e.late[msg.ID]; !ok {
+		return false
+	}
+
+	delete(e.late, msg.ID)
+	return true
+}
+
+// unclaimed handles messages that do not belong to a running probe.
+func (e *Exporter) unclaimed(msg *ping.Message) {
+	if msg.Kind == matrix.PingResponse && e.isLate(msg) {
+		slog.Info("Received late pong", "network", msg.Room, "ping_id", msg.ID)
+		e.metrics.failures.WithLabelValues(msg.Room, string(ping.ReasonPongLate)).Inc()
+		return
+	}
+
+	if instance, seq, ok := util.ParseID(msg.ID); ok && instance != e.ids.Instance {
+		slog.Debug("Ignoring message of other exporter", "kind", msg.Kind, "ping_id", msg.ID, "instance", instance, "seq", seq)
+	} else {
+		slog.Debug("Ignoring message", "kind", msg.Kind, "ping_id", msg.ID)
+	}
+	e.unclaimedCount.Add(1)
+}
+
+// malformedPong registers a malformed ping reply for a network.
+func (e *Exporter) malformedPong(network string) {
+	e.metrics.failures.WithLabelValues(network, string(ping.ReasonPongMalformed)).Inc()
+}
+
+// rejected registers a rejected message for a network.
+func (e *Exporter) rejected(network, reason string) {
+	e.metrics.rejected.WithLabelValues(network, reason).Inc()
+}
+
+// normalizeDelay fills in the timestamps of a delay that can only be derived
+// from the combination of the ping and the ping reply.
+func normalizeDelay(d *ping.Delay) {
+	if d.Ping == nil {
+		return
+	}
+
+	// We know from the ping reply when the ping was actually received,
+	// or approximately when the reply does not contain the receive time.
+	switch {
+	case d.Pong == nil:
+		return
+	case !d.Pong.PingReceived.IsZero():
+		d.Ping.Received = d.Pong.PingReceived
+	default:
+		d.Ping.Received = d.Pong.Sent
+	}
+
+	// The reply contains the time the IRC server stamped the ping, if supported.
+	d.Ping.IRC = d.Pong.PingIRC
+}
+
+// sendPings sends pings to the given rooms and returns the sent pings by network name.
+// Pings are sent concurrently, limited by the configured concurrency across all probes.
+// Networks for which the ping could not be sent are omitted.
+// Replies to the sent pings are delivered to the given channel.
+func (e *Exporter) sendPings(ctx context.Context, rooms map[string]id.RoomID, replies chan<- *ping.Message) (sent map[string]*ping.Message) {
+	slog.Debug("Sending pings", "count", len(rooms), "concurrency", e.Concurrency)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sent = make(map[string]*ping.Message, len(rooms))
+	for n, roomID := range rooms {
+		wg.Add(1)
+		e.sendSem <- struct{}{}
+
+		go func(n string, roomID id.RoomID) {
+			defer wg.Done()
+			defer func() { <-e.sendSem }()
+
+			msg, err := e.sendPing(ctx, n, roomID, replies)
+			if err != nil {
+				slog.Warn("Error sending ping", "network", n, "room_id", roomID, "err", err)
+				return
+			}
+
+			mu.Lock()
+			sent[n] = msg
+			mu.Unlock()
+		}(n, roomID)
+	}
+
+	wg.Wait()
+
+	slog.Debug("Sent pings", "count", len(sent))
+
+	return
+}
+
+// sendPing sends a ping to a single room and returns the sent ping.
+// Replies to the ping are delivered to the given channel.
+func (e *Exporter) sendPing(ctx context.Context, network string, roomID id.RoomID, replies chan<- *ping.Message) (*ping.Message, error) {
+	// Create random ID and register it
+	msg := &ping.Message{Kind: matrix.PingMessage, Room: network, ID: e.ids.Next()}
+	e.dispatcher.Register(msg.ID, replies)
+
+	// Try to send a ping message
+	msg.Sent = time.Now()
+	_, err := e.SendPing(ctx, roomID, msg.ID, msg.Sent)
+	if err != nil {
+		e.dispatcher.Unregister(msg.ID)
+		return nil, err
+	}
+
+	e.metrics.sent.WithLabelValues(network).Inc()
+
+	return msg, nil
+}
+
+// getDelays returns the delays of the sent pings.
+func (e *Exporter) getDelays(ctx context.Context, rooms map[string]id.RoomID, sent map[string]*ping.Message, replies <-chan *ping.Message) (delays map[string]*ping.Delay) {
+	slog.Debug("Waiting for replies")
+
+	// Initialise delays map with nil pointers
+	delays = make(map[string]*ping.Delay, len(rooms))
+	for n := range rooms {
+		delays[n] = new(ping.Delay)
+	}
+
+	// Check for incoming messages and return when done,
+	// or when the timeout is reached.
+	pingCount := 0
+	pongCount := 0
+	for {
+		if pingCount == len(sent) && pongCount == len(sent) {
+			return
+		}
+
+		select {
+		case msg := <-replies:
+			// Check if this is a ping we sent
+			p, ok := sent[msg.Room]
+			if !ok || p.ID != msg.ID {
+				slog.Debug("Ignoring message", "kind", msg.Kind, "ping_id", msg.ID)
+				continue
+			}
+
+			switch {
+			case msg.Kind == matrix.PingMessage && delays[msg.Room].Ping == nil:
+				// Store message as ping
+				msg.Sent = p.Sent
+				delays[msg.Room].Ping = msg
+				pingCount++
+
+				slog.Debug("Received ping", "room_id", msg.Room, "delay", msg.ToMatrix())
+				e.events.publish(msg.Room, "ping_matrix", msg, msg.ToMatrix())
+
+			case msg.Kind == matrix.PingResponse && delays[msg.Room].Pong == nil:
+				// Check if the pong was sent by the expected responder
+				if !e.validResponder(msg) {
+					slog.Warn("Ignoring pong from unexpected responder", "network", msg.Room, "sender", msg.Sender)
+					e.metrics.failures.WithLabelValues(msg.Room, string(ping.ReasonPongUnexpected)).Inc()
+					continue
+				}
+
+				// Store message as pong
+				delays[msg.Room].Pong = msg
+				pongCount++
+
+				slog.Debug("Received pong", "room_id", msg.Room, "total_delay", msg.Total())
+				e.events.publish(msg.Room, "pong", msg, msg.Total())
+
+				// The ping reply tells when the ping arrived on IRC
+				if pm := delays[msg.Room].Ping; pm != nil && !msg.PingReceived.IsZero() {
+					e.events.publish(msg.Room, "ping_irc", msg, msg.PingReceived.Sub(pm.Matrix))
+				}
+			}
+
+		case <-ctx.Done():
+			slog.Info("Timed out waiting for replies.")
+			return
+		}
+	}
+}
@@ -5,171 +5,370 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"maunium.net/go/mautrix/id"
 
+	"github.com/silkeh/matrix_irc_ping_exporter/history"
+	"github.com/silkeh/matrix_irc_ping_exporter/irc"
 	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
 	"github.com/silkeh/matrix_irc_ping_exporter/ping"
 	"github.com/silkeh/matrix_irc_ping_exporter/util"
 )
 
-const idSize = 8
+var (
+	pingDelayDesc = prometheus.NewDesc("matrix_irc_ping_delay_seconds",
+		"Delay of the ping from the exporter to IRC.", []string{"network"}, nil)
+	pingMatrixDelayDesc = prometheus.NewDesc("matrix_irc_ping_matrix_delay_seconds",
+		"Delay of the ping from the exporter to Matrix.", []string{"network"}, nil)
+	pingIRCDelayDesc = prometheus.NewDesc("matrix_irc_ping_irc_delay_seconds",
+		"Delay of the ping from Matrix to IRC.", []string{"network"}, nil)
+	pingBridgeDelayDesc = prometheus.NewDesc("matrix_irc_ping_bridge_delay_seconds",
+		"Delay of the ping from Matrix to the IRC server.", []string{"network"}, nil)
+	pingIRCServerDelayDesc = prometheus.NewDesc("matrix_irc_ping_ircd_delay_seconds",
+		"Delay of the ping from the IRC server to the responder.", []string{"network"}, nil)
+	pongDelayDesc = prometheus.NewDesc("matrix_irc_pong_delay_seconds",
+		"Delay of the pong from IRC to the exporter.", []string{"network"}, nil)
+	pongMatrixDelayDesc = prometheus.NewDesc("matrix_irc_pong_matrix_delay_seconds",
+		"Delay of the pong from Matrix to the exporter.", []string{"network"}, nil)
+	pongIRCDelayDesc = prometheus.NewDesc("matrix_irc_pong_irc_delay_seconds",
+		"Delay of the pong from IRC to Matrix.", []string{"network"}, nil)
+	rttDesc = prometheus.NewDesc("matrix_irc_rtt_seconds",
+		"Round trip time from the exporter to IRC and back.", []string{"network"}, nil)
+	successDesc = prometheus.NewDesc("matrix_irc_ping_success",
+		"Whether the ping reply was received in time.", []string{"network"}, nil)
+	lastProbeDesc = prometheus.NewDesc("matrix_irc_last_probe_timestamp_seconds",
+		"Time of the last completed probe, in seconds since the Unix epoch.", []string{"network"}, nil)
+	clockOffsetDesc = prometheus.NewDesc("matrix_irc_clock_offset_seconds",
+		"Estimated clock offset of the IRC responder relative to the exporter.", []string{"network"}, nil)
+	droppedDesc = prometheus.NewDesc("matrix_irc_messages_dropped_total",
+		"Number of received ping messages that were dropped, by reason.", []string{"reason"}, nil)
+	limitedDesc = prometheus.NewDesc("matrix_irc_commands_limited_total",
+		"Number of Matrix commands that were ignored because of rate limits or allow and deny lists.", nil, nil)
+)
+
+// DefaultConcurrency is the default maximum number of pings that are sent at the same time.
+const DefaultConcurrency = 4
+
+// Config is the configuration for an Exporter.
+type Config struct {
+	// Rooms contains the rooms to probe, by network name.
+	Rooms map[string]id.RoomID
+
+	// IRC contains the IRC clients used for reverse probes, by network name.
+	IRC map[string]*irc.Client
+
+	// Probes contains the probe definitions, by network name.
+	Probes map[string]*Probe
+
+	// Timeout is the maximum duration of a single probe.
+	Timeout time.Duration
+
+	// Interval is the interval between background probes.
+	Interval time.Duration
+
+	// Concurrency is the maximum number of pings that are sent at the same time.
+	// Defaults to DefaultConcurrency.
+	Concurrency int
+
+	// Instance is the name of this exporter, used in all probe IDs.
+	// A random name is used if this is empty.
+	Instance string
+
+	// CorrectClockOffset enables correction of the delays for the
+	// estimated clock offset of the IRC responder.
+	CorrectClockOffset bool
+
+	// Buckets contains the buckets for the delay histograms, in seconds.
+	// DefaultBuckets are used if this is empty.
+	Buckets []float64
+
+	// Window is the number of background probes used for the rolling statistics.
+	// Defaults to ping.DefaultWindow.
+	Window int
+
+	// History stores the results of all probes, if set.
+	History *history.Store
+
+	// OnResult is called with the result of every background probe, if set.
+	OnResult func(r *ping.Result)
+}
 
 // Exporter is a Prometheus exporter for Matrix-IRC ping metrics.
 type Exporter struct {
 	*matrix.Client
-	Rooms   map[string]id.RoomID
-	Timeout time.Duration
+	Rooms       map[string]id.RoomID
+	IRCClients  map[string]*irc.Client
+	Probes      map[string]*Probe
+	Timeout     time.Duration
+	Interval    time.Duration
+	Concurrency int
+
+	// CorrectClockOffset enables correction of the delays for the
+	// estimated clock offset of the IRC responder.
+	CorrectClockOffset bool
+
+	// History stores the results of all probes, if set.
+	History *history.Store
+
+	// OnResult is called with the result of every background probe, if set.
+	OnResult func(r *ping.Result)
+
+	registry   *prometheus.Registry
+	handler    http.Handler
+	metrics    *metrics
+	dispatcher *ping.Dispatcher
+	ids        *util.IDGenerator
+
+	// sendSem limits the number of pings that are sent at the same time by all probes
+	sendSem chan struct{}
+
+	// ircDispatcher routes ping replies received on IRC to the running reverse probes
+	ircDispatcher *ping.Dispatcher
+
+	mu             sync.RWMutex
+	results        map[string]*ping.Result
+	reverseResults map[string]*ping.Result
+
+	// events distributes the messages of running probes to live event streams
+	events *broker
+
+	// stats contains the rolling statistics of the background probes, by network name
+	stats map[string]*ping.Stats
+
+	lateMu sync.Mutex
+	late   map[string]*ping.Result
+
+	unclaimedCount atomic.Uint64
 }
 
 // NewExporter returns a configured ping metrics exporter.
-func NewExporter(client *matrix.Client, rooms map[string]id.RoomID, timeout time.Duration) *Exporter {
-	return &Exporter{
-		Client:  client,
-		Rooms:   rooms,
-		Timeout: timeout,
+// Incoming messages are routed to the running probes in the background.
+// The background probes are started by calling [Exporter.Run].
+func NewExporter(client *matrix.Client, config *Config) (*Exporter, error) {
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
 	}
-}
-
-// MetricsHandler is an HTTP handler that collects metrics.
-func (e *Exporter) MetricsHandler(w http.ResponseWriter, r *http.Request) {
-	slog.Info("Handling metrics request", "timeout", e.Timeout)
 
-	ctx, cancel := context.WithTimeout(r.Context(), e.Timeout)
-	defer cancel()
+	buckets := config.Buckets
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
 
-	// Send ping to all rooms
-	ids, err := e.sendPings(ctx)
+	ids, err := util.NewIDGenerator(config.Instance)
 	if err != nil {
-		slog.Warn("Error sending pings", "err", err)
+		return nil, err
 	}
 
-	slog.Debug("Pings sent, getting delays")
-
-	// Read all delays
-	delays := e.getDelays(ctx, ids)
+	networks := make([]string, 0, len(config.Rooms))
+	stats := make(map[string]*ping.Stats, len(config.Rooms))
+	for n := range config.Rooms {
+		networks = append(networks, n)
+		stats[n] = ping.NewStats(config.Window)
+	}
 
-	slog.Debug("Got delays")
+	e := &Exporter{
+		Client:             client,
+		Rooms:              config.Rooms,
+		IRCClients:         config.IRC,
+		Probes:             config.Probes,
+		Timeout:            config.Timeout,
+		Interval:           config.Interval,
+		Concurrency:        concurrency,
+		CorrectClockOffset: config.CorrectClockOffset,
+		History:            config.History,
+		OnResult:           config.OnResult,
+		registry:           prometheus.NewRegistry(),
+		metrics:            newMetrics(networks, buckets),
+		dispatcher:         ping.NewDispatcher(),
+		ids:                ids,
+		sendSem:            make(chan struct{}, concurrency),
+		ircDispatcher:      ping.NewDispatcher(),
+		results:            make(map[string]*ping.Result, len(config.Rooms)),
+		reverseResults:     make(map[string]*ping.Result, len(config.IRC)),
+		stats:              stats,
+		events:             newBroker(),
+		late:               make(map[string]*ping.Result),
+	}
 
-	// Write metrics
-	// TODO: use proper exporter functionality for this
-	for n, d := range delays {
-		success := 0
+	client.HandleMalformedPong(e.malformedPong)
+	client.HandleRejected(e.rejected)
+	for n, c := range config.IRC {
+		n := n
+		c.HandleRejected(func(_, reason string) { e.rejected(n, reason) })
+	}
 
-		// Client to matrix
-		if d.Ping != nil {
-			// Received time is 'our' received time, ergo: loopback time.
-			d.Ping.Matrix = d.Ping.Received
+	// Route incoming messages to the running probes
+	e.dispatcher.Unclaimed = e.unclaimed
+	go e.dispatcher.Run(client.Pings, client.Pongs)
 
-			fmt.Fprintf(w, "matrix_irc_ping_matrix_delay_seconds{network=\"%s\"} %v\n", d.Ping.Room, d.Ping.ToMatrix().Seconds())
+	// Route ping replies received on IRC to the running reverse probes
+	var ircPongs []<-chan *ping.Message
+	for _, c := range config.IRC {
+		if c.Pongs != nil {
+			ircPongs = append(ircPongs, c.Pongs)
 		}
+	}
+	e.ircDispatcher.Unclaimed = e.unclaimed
+	go e.ircDispatcher.Run(ircPongs...)
 
-		// Complete path
-		if d.Ping != nil && d.Pong != nil {
-			success = 1
+	e.registry.MustRegister(
+		e,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	e.registry.MustRegister(e.metrics.collectors()...)
 
-			// We now from the ping reply when the ping was actually received
-			d.Ping.Received = d.Pong.Sent
+	e.handler = promhttp.HandlerFor(e.registry, promhttp.HandlerOpts{
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelError),
+	})
 
-			// Matrix to IRC
-			fmt.Fprintf(w, "matrix_irc_ping_delay_seconds{network=\"%s\"} %v\n", d.Ping.Room, d.Ping.Total().Seconds())
-			fmt.Fprintf(w, "matrix_irc_ping_irc_delay_seconds{network=\"%s\"} %v\n", d.Ping.Room, d.Ping.FromMatrix().Seconds())
+	return e, nil
+}
 
-			// IRC to Matrix
-			fmt.Fprintf(w, "matrix_irc_pong_delay_seconds{network=\"%s\"} %v\n", d.Pong.Room, d.Pong.Total().Seconds())
-			fmt.Fprintf(w, "matrix_irc_pong_matrix_delay_seconds{network=\"%s\"} %v\n", d.Pong.Room, d.Pong.FromMatrix().Seconds())
-			fmt.Fprintf(w, "matrix_irc_pong_irc_delay_seconds{network=\"%s\"} %v\n", d.Pong.Room, d.Pong.ToMatrix().Seconds())
+// Instance returns the name of this exporter, as used in probe IDs.
+func (e *Exporter) Instance() string {
+	return e.ids.Instance
+}
 
-			// Complete path
-			fmt.Fprintf(w, "matrix_irc_rtt_seconds{network=\"%s\"} %v\n", n, d.RTT().Seconds())
-		}
+// Results returns the result of the latest background probe of every network.
+// Networks that have not been probed yet have a nil result.
+func (e *Exporter) Results() map[string]*ping.Result {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-		// Success status
-		fmt.Fprintf(w, "matrix_irc_ping_success{network=\"%s\"} %v\n", n, success)
+	results := make(map[string]*ping.Result, len(e.Rooms))
+	for n := range e.Rooms {
+		results[n] = e.results[n]
 	}
+
+	return results
 }
 
-// sendPings sends pings to all configured rooms and returns a map with ping IDs
-func (e *Exporter) sendPings(ctx context.Context) (ids map[string]time.Time, err error) {
-	slog.Debug("Sending pings", "count", len(e.Rooms))
-
-	ids = make(map[string]time.Time, len(e.Rooms))
-	for _, roomID := range e.Rooms {
-		// Create random ID and register it
-		id := util.RandString(idSize)
-		ts := time.Now()
-		ids[id] = ts
-
-		// Try to send a ping message
-		_, err = e.SendPing(ctx, roomID, id, ts)
-		if err != nil {
-			return nil, fmt.Errorf("send ping to %q: %w", roomID, err)
-		}
+// ProbeNetwork probes a single network, and returns the result.
+// The probe is limited by the configured timeout.
+func (e *Exporter) ProbeNetwork(ctx context.Context, network string) (*ping.Result, error) {
+	roomID, ok := e.Rooms[network]
+	if !ok {
+		return nil, fmt.Errorf("unknown network: %q", network)
 	}
 
-	slog.Debug("Sent pings", "count", len(e.Rooms))
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
 
-	return
+	return e.Probe(ctx, map[string]id.RoomID{network: roomID})[network], nil
 }
 
-// getDelays returns the sent delays.
-func (e *Exporter) getDelays(ctx context.Context, ids map[string]time.Time) (delays map[string]*ping.Delay) {
-	slog.Debug("Waiting for replies")
+// MetricsHandler is an HTTP handler that returns the metrics of the latest probes.
+func (e *Exporter) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Debug("Handling metrics request")
 
-	// Initialise delays map with nil pointers
-	delays = make(map[string]*ping.Delay, len(e.Rooms))
-	for n := range e.Rooms {
-		delays[n] = new(ping.Delay)
+	e.handler.ServeHTTP(w, r)
+}
+
+// Describe implements [prometheus.Collector].
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pingDelayDesc
+	ch <- pingMatrixDelayDesc
+	ch <- pingIRCDelayDesc
+	ch <- pingBridgeDelayDesc
+	ch <- pingIRCServerDelayDesc
+	ch <- pongDelayDesc
+	ch <- pongMatrixDelayDesc
+	ch <- pongIRCDelayDesc
+	ch <- rttDesc
+	ch <- successDesc
+	ch <- lastProbeDesc
+	ch <- clockOffsetDesc
+	ch <- droppedDesc
+	ch <- limitedDesc
+	ch <- reversePingDelayDesc
+	ch <- reversePongDelayDesc
+	ch <- reverseRTTDesc
+	ch <- reverseSuccessDesc
+	ch <- rttMinDesc
+	ch <- rttAvgDesc
+	ch <- rttMaxDesc
+	ch <- rttStdDevDesc
+	ch <- jitterDesc
+	ch <- lossDesc
+	ch <- windowDesc
+}
+
+// Collect implements [prometheus.Collector].
+// It returns the metrics of the latest probe of every room.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue,
+		float64(e.Client.Dropped()), "buffer_full")
+	ch <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue,
+		float64(e.unclaimedCount.Load()), "unclaimed")
+	ch <- prometheus.MustNewConstMetric(limitedDesc, prometheus.CounterValue, float64(e.Client.Limited()))
+
+	for n, s := range e.stats {
+		collectStats(ch, n, s)
 	}
 
-	// Check for incoming messages and return when done,
-	// or when the timeout is reached.
-	pingCount := 0
-	pongCount := 0
-	for {
-		select {
-		case msg := <-e.Pings:
-			// Check if this is a ping we sent
-			ts, ok := ids[msg.ID]
-			if !ok {
-				slog.Debug("Ignoring ping", "ping_id", msg.ID)
-				continue
-			}
-
-			// Store message as ping
-			msg.Sent = ts
-			delays[msg.Room].Ping = msg
-
-			slog.Debug("Received ping", "room_id", msg.Room, "delay", msg.ToMatrix())
-
-			// Stop when everything has been received
-			pingCount++
-			if pingCount == len(e.Rooms) && pongCount == len(e.Rooms) {
-				return
-			}
-
-		case msg := <-e.Pongs:
-			// Check if this is a ping we sent
-			if _, ok := ids[msg.ID]; !ok {
-				slog.Debug("Ignoring pong", "ping_id", msg.ID)
-				continue
-			}
-
-			// Store message as pong
-			delays[msg.Room].Pong = msg
-
-			slog.Debug("Received pong", "room_id", msg.Room, "total_delay", msg.Total())
-
-			// Stop when everything has been received
-			pongCount++
-			if pingCount == len(e.Rooms) && pongCount == len(e.Rooms) {
-				return
-			}
-
-		case <-ctx.Done():
-			slog.Info("Timed out waiting for replies.")
-			return
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for n, r := range e.results {
+		collectDelay(ch, r)
+		ch <- prometheus.MustNewConstMetric(lastProbeDesc, prometheus.GaugeValue,
+			float64(r.Time.UnixNano())/1e9, n)
+	}
+
+	for _, r := range e.reverseResults {
+		collectReverse(ch, r)
+	}
+}
+
+// collectDelay writes the metrics for the delay of a single probe.
+func collectDelay(ch chan<- prometheus.Metric, r *ping.Result) {
+	network, d := r.Network, r.Delay
+	success := 0.0
+
+	// Client to matrix
+	if d.Ping != nil {
+		ch <- gauge(pingMatrixDelayDesc, d.Ping.ToMatrix(), network)
+	}
+
+	// Complete path
+	if d.Ping != nil && d.Pong != nil {
+		success = 1
+
+		// Matrix to IRC
+		ch <- gauge(pingDelayDesc, d.Ping.Total(), network)
+		ch <- gauge(pingIRCDelayDesc, d.Ping.FromMatrix(), network)
+
+		// Matrix to IRC server to IRC, if the IRC server time is known
+		if !d.Ping.IRC.IsZero() {
+			ch <- gauge(pingBridgeDelayDesc, d.Ping.ToIRC(), network)
+			ch <- gauge(pingIRCServerDelayDesc, d.Ping.FromIRC(), network)
 		}
+
+		// IRC to Matrix
+		ch <- gauge(pongDelayDesc, d.Pong.Total(), network)
+		ch <- gauge(pongMatrixDelayDesc, d.Pong.FromMatrix(), network)
+		ch <- gauge(pongIRCDelayDesc, d.Pong.ToMatrix(), network)
+
+		// Complete path
+		ch <- gauge(rttDesc, d.RTT(), network)
+
+		// Clock offset of the responder
+		ch <- gauge(clockOffsetDesc, r.ClockOffset, network)
 	}
+
+	// Success status
+	ch <- prometheus.MustNewConstMetric(successDesc, prometheus.GaugeValue, success, network)
+}
+
+// gauge returns a gauge metric containing a duration in seconds.
+func gauge(desc *prometheus.Desc, d time.Duration, labels ...string) prometheus.Metric {
+	return prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, d.Seconds(), labels...)
 }
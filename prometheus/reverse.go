@@ -0,0 +1,137 @@
+package prometheus
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/irc"
+	"github.com/silkeh/matrix_irc_ping_exporter/matrix"
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+var (
+	reversePingDelayDesc = prometheus.NewDesc("matrix_irc_reverse_ping_delay_seconds",
+		"Delay of the reverse ping from IRC to Matrix.", []string{"network"}, nil)
+	reversePongDelayDesc = prometheus.NewDesc("matrix_irc_reverse_pong_delay_seconds",
+		"Delay of the reverse ping reply from Matrix to IRC.", []string{"network"}, nil)
+	reverseRTTDesc = prometheus.NewDesc("matrix_irc_reverse_rtt_seconds",
+		"Round trip time from IRC to Matrix and back.", []string{"network"}, nil)
+	reverseSuccessDesc = prometheus.NewDesc("matrix_irc_reverse_ping_success",
+		"Whether the reverse ping reply was received in time.", []string{"network"}, nil)
+)
+
+// ReverseProbe sends a ping from IRC, and returns the result.
+// The ping is expected to arrive in Matrix, where it is answered by the Matrix client.
+// The probe ends when the reply has been received on IRC, or when the context is done.
+func (e *Exporter) ReverseProbe(ctx context.Context, network string, client *irc.Client) *ping.Result {
+	r := &ping.Result{Network: network, Time: time.Now(), Delay: new(ping.Delay)}
+
+	if !client.Connected() {
+		slog.Warn("Error sending reverse ping", "network", network, "err", "not connected")
+		r.Reason = ping.ReasonSendFailed
+		return r
+	}
+
+	// Create random ID and register it for the replies on both Matrix and IRC
+	r.ID = e.ids.Next()
+	matrixReplies := make(chan *ping.Message, 4)
+	ircReplies := make(chan *ping.Message, 4)
+	e.dispatcher.Register(r.ID, matrixReplies)
+	e.ircDispatcher.Register(r.ID, ircReplies)
+	defer e.dispatcher.Unregister(r.ID)
+	defer e.ircDispatcher.Unregister(r.ID)
+
+	// Send the ping
+	sent := time.Now()
+	client.SendPing(r.ID, sent)
+
+	d := r.Delay
+	for d.Ping == nil || d.Pong == nil {
+		select {
+		case msg := <-matrixReplies:
+			if msg.Kind != matrix.PingMessage || d.Ping != nil {
+				continue
+			}
+
+			msg.Sent = sent
+			d.Ping = msg
+
+			slog.Debug("Received reverse ping", "network", network, "delay", msg.ToMatrix())
+			e.events.publish(network, "reverse_ping", msg, msg.ToMatrix())
+
+		case msg := <-ircReplies:
+			if d.Pong != nil {
+				continue
+			}
+
+			d.Pong = msg
+
+			slog.Debug("Received reverse pong", "network", network, "rtt", msg.Received.Sub(sent))
+			e.events.publish(network, "reverse_pong", msg, msg.Total())
+
+		case <-ctx.Done():
+			slog.Info("Timed out waiting for reverse replies.", "network", network)
+
+			if d.Ping == nil {
+				r.Reason = ping.ReasonPingLost
+			} else {
+				r.Reason = ping.ReasonPongLost
+			}
+
+			return r
+		}
+	}
+
+	return r
+}
+
+// reverseProbes runs a reverse probe for every IRC client with a probe channel,
+// and stores the results.
+func (e *Exporter) reverseProbes(ctx context.Context) {
+	results := make(chan *ping.Result)
+	count := 0
+
+	for n, c := range e.IRCClients {
+		if c.ProbeChannel == "" {
+			continue
+		}
+
+		count++
+		go func(n string, c *irc.Client) {
+			results <- e.ReverseProbe(ctx, n, c)
+		}(n, c)
+	}
+
+	for i := 0; i < count; i++ {
+		r := <-results
+
+		e.mu.Lock()
+		e.reverseResults[r.Network] = r
+		e.mu.Unlock()
+	}
+}
+
+// collectReverse writes the metrics for a single reverse probe.
+func collectReverse(ch chan<- prometheus.Metric, r *ping.Result) {
+	d := r.Delay
+	success := 0.0
+
+	// IRC to Matrix
+	if d.Ping != nil {
+		ch <- gauge(reversePingDelayDesc, d.Ping.ToMatrix(), r.Network)
+	}
+
+	// Complete path
+	if r.Success() {
+		success = 1
+
+		// Matrix to IRC
+		ch <- gauge(reversePongDelayDesc, d.Pong.Total(), r.Network)
+		ch <- gauge(reverseRTTDesc, d.RTT(), r.Network)
+	}
+
+	ch <- prometheus.MustNewConstMetric(reverseSuccessDesc, prometheus.GaugeValue, success, r.Network)
+}
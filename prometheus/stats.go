@@ -0,0 +1,43 @@
+package prometheus
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+var (
+	rttMinDesc = prometheus.NewDesc("matrix_irc_rtt_min_seconds",
+		"Minimum round trip time of the probes in the window.", []string{"network"}, nil)
+	rttAvgDesc = prometheus.NewDesc("matrix_irc_rtt_avg_seconds",
+		"Average round trip time of the probes in the window.", []string{"network"}, nil)
+	rttMaxDesc = prometheus.NewDesc("matrix_irc_rtt_max_seconds",
+		"Maximum round trip time of the probes in the window.", []string{"network"}, nil)
+	rttStdDevDesc = prometheus.NewDesc("matrix_irc_rtt_stddev_seconds",
+		"Standard deviation of the round trip time of the probes in the window.", []string{"network"}, nil)
+	jitterDesc = prometheus.NewDesc("matrix_irc_jitter_seconds",
+		"Jitter of the round trip time of the probes in the window.", []string{"network"}, nil)
+	lossDesc = prometheus.NewDesc("matrix_irc_loss_ratio",
+		"Fraction of failed probes in the window.", []string{"network"}, nil)
+	windowDesc = prometheus.NewDesc("matrix_irc_window_probes",
+		"Number of probes in the window.", []string{"network"}, nil)
+)
+
+// collectStats writes the rolling statistics of a single network.
+// The round trip time statistics are omitted if no probe in the window succeeded.
+func collectStats(ch chan<- prometheus.Metric, network string, s *ping.Stats) {
+	sum := s.Summary()
+
+	ch <- prometheus.MustNewConstMetric(windowDesc, prometheus.GaugeValue, float64(sum.Count), network)
+	ch <- prometheus.MustNewConstMetric(lossDesc, prometheus.GaugeValue, sum.Loss(), network)
+
+	if sum.Count == sum.Lost {
+		return
+	}
+
+	ch <- gauge(rttMinDesc, sum.Min, network)
+	ch <- gauge(rttAvgDesc, sum.Avg, network)
+	ch <- gauge(rttMaxDesc, sum.Max, network)
+	ch <- gauge(rttStdDevDesc, sum.StdDev, network)
+	ch <- gauge(jitterDesc, sum.Jitter, network)
+}
@@ -0,0 +1,173 @@
+package prometheus
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+const (
+	sparkBarWidth  = 6
+	sparkBarGap    = 2
+	sparkHeight    = 20
+	sparkMinHeight = 2
+)
+
+const statusPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>Matrix-IRC bridge status</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 0.3em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+.ok { color: #2a8a2a; fill: #2a8a2a; }
+.fail { color: #c0392b; fill: #c0392b; }
+.unknown { color: #888; }
+</style>
+</head>
+<body>
+<h1>Matrix-IRC bridge status</h1>
+<table>
+<tr><th>Network</th><th>Status</th><th>Last RTT</th><th>History</th><th>Last success</th><th>Last failure</th></tr>
+{{- range .Networks}}
+<tr>
+<td>{{.Name}}</td>
+{{- if not .Last}}
+<td class="unknown">unknown</td><td>-</td>
+{{- else if .Last.Success}}
+<td class="ok">ok</td><td>{{formatDuration .Last.Delay.RTT}}</td>
+{{- else}}
+<td class="fail">{{.Last.Reason}}</td><td>-</td>
+{{- end}}
+<td><svg width="{{.Width}}" height="{{$.Height}}">
+{{- range .Bars}}<rect x="{{.X}}" y="{{.Y}}" width="{{$.BarWidth}}" height="{{.Height}}" class="{{.Class}}"><title>{{.Title}}</title></rect>{{end -}}
+</svg></td>
+<td>{{formatTime .Summary.LastSuccess}}</td>
+<td>{{formatTime .Summary.LastFailure}}</td>
+</tr>
+{{- end}}
+</table>
+<p>Generated at {{formatTime .Time}}.</p>
+</body>
+</html>
+`
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"formatDuration": func(d time.Duration) string { return d.Round(time.Millisecond).String() },
+	"formatTime":     formatTime,
+}).Parse(statusPage))
+
+// status contains the data for the status page.
+type status struct {
+	Time     time.Time
+	Refresh  int
+	Height   int
+	BarWidth int
+	Networks []*networkStatus
+}
+
+// networkStatus contains the status of a single network.
+type networkStatus struct {
+	Name    string
+	Last    *ping.Result
+	Summary *ping.Summary
+	Bars    []sparkBar
+	Width   int
+}
+
+// sparkBar is a single bar in a sparkline.
+type sparkBar struct {
+	X, Y, Height int
+	Class, Title string
+}
+
+// StatusHandler is an HTTP handler that returns a status page of all networks.
+// The page shows the result of the latest background probe,
+// and a sparkline of the probes in the statistics window.
+func (e *Exporter) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	slog.Debug("Handling status request")
+
+	s := &status{
+		Time:     time.Now(),
+		Refresh:  int(e.Interval.Seconds()),
+		Height:   sparkHeight,
+		BarWidth: sparkBarWidth,
+	}
+	if s.Refresh <= 0 {
+		s.Refresh = 60
+	}
+
+	e.mu.RLock()
+	for n := range e.Rooms {
+		results := e.stats[n].Results()
+		s.Networks = append(s.Networks, &networkStatus{
+			Name:    n,
+			Last:    e.results[n],
+			Summary: e.stats[n].Summary(),
+			Bars:    sparkline(results),
+			Width:   len(results) * (sparkBarWidth + sparkBarGap),
+		})
+	}
+	e.mu.RUnlock()
+
+	sort.Slice(s.Networks, func(i, j int) bool {
+		return s.Networks[i].Name < s.Networks[j].Name
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, s); err != nil {
+		slog.Error("Error rendering status page", "err", err)
+	}
+}
+
+// sparkline returns the bars of a sparkline of probe results.
+// Successful probes are scaled by their round trip time, failed probes have the full height.
+func sparkline(results []*ping.Result) []sparkBar {
+	var maxRTT time.Duration
+	for _, r := range results {
+		if r.Success() && r.Delay.RTT() > maxRTT {
+			maxRTT = r.Delay.RTT()
+		}
+	}
+
+	bars := make([]sparkBar, len(results))
+	for i, r := range results {
+		b := sparkBar{X: i * (sparkBarWidth + sparkBarGap), Height: sparkHeight, Class: "fail"}
+
+		if r.Success() {
+			b.Class = "ok"
+			b.Title = r.Time.Format(time.RFC3339) + ": " + r.Delay.RTT().Round(time.Millisecond).String()
+			if maxRTT > 0 {
+				b.Height = max(int(sparkHeight*r.Delay.RTT()/maxRTT), sparkMinHeight)
+			}
+		} else {
+			b.Title = r.Time.Format(time.RFC3339) + ": " + string(r.Reason)
+		}
+
+		b.Y = sparkHeight - b.Height
+		bars[i] = b
+	}
+
+	return bars
+}
+
+// formatTime formats a time for the status page, or returns "never" for the zero time.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,91 @@
+package prometheus
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"maunium.net/go/mautrix/id"
+
+	"github.com/silkeh/matrix_irc_ping_exporter/ping"
+)
+
+// scrapeTimeoutOffset is subtracted from the scrape timeout set by Prometheus,
+// to leave some time for the response to be sent.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+var probeDurationDesc = prometheus.NewDesc("matrix_irc_probe_duration_seconds",
+	"Duration of the probe.", nil, nil)
+
+// targetCollector is a collector for the result of a single probe.
+type targetCollector struct {
+	result   *ping.Result
+	duration time.Duration
+}
+
+// Describe implements [prometheus.Collector].
+func (c *targetCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+// Collect implements [prometheus.Collector].
+func (c *targetCollector) Collect(ch chan<- prometheus.Metric) {
+	collectDelay(ch, c.result)
+	ch <- prometheus.MustNewConstMetric(probeDurationDesc, prometheus.GaugeValue, c.duration.Seconds())
+}
+
+// ProbeHandler is an HTTP handler that probes a single network,
+// and returns the metrics for that network.
+// The network is given using the `target` parameter.
+func (e *Exporter) ProbeHandler(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	roomID, ok := e.Rooms[target]
+	if !ok {
+		http.Error(w, "unknown target: "+strconv.Quote(target), http.StatusBadRequest)
+		return
+	}
+
+	timeout := e.scrapeTimeout(r)
+
+	slog.Debug("Handling probe request", "target", target, "timeout", timeout)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	results := e.Probe(ctx, map[string]id.RoomID{target: roomID})
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(&targetCollector{
+		result:   results[target],
+		duration: time.Since(start),
+	})
+
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// scrapeTimeout returns the timeout for a probe request.
+// This is the configured timeout, or the scrape timeout of Prometheus if that is shorter.
+func (e *Exporter) scrapeTimeout(r *http.Request) time.Duration {
+	v := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if v == "" {
+		return e.Timeout
+	}
+
+	seconds, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("Invalid scrape timeout", "value", v, "err", err)
+		return e.Timeout
+	}
+
+	timeout := time.Duration(seconds*float64(time.Second)) - scrapeTimeoutOffset
+	if timeout <= 0 || timeout > e.Timeout {
+		return e.Timeout
+	}
+
+	return timeout
+}
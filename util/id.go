@@ -0,0 +1,75 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// encoding is the lower case base32 encoding used for IDs.
+var encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+const (
+	// instanceSize is the number of random bytes in a generated instance name.
+	instanceSize = 5
+
+	// nonceSize is the number of random bytes in an ID.
+	nonceSize = 5
+)
+
+// IDGenerator generates unique probe IDs.
+// IDs have the format `<instance>-<sequence>-<nonce>`,
+// where the sequence is a base 36 number, and the nonce is random.
+type IDGenerator struct {
+	Instance string
+	seq      atomic.Uint64
+}
+
+// NewIDGenerator returns an IDGenerator for an instance.
+// A random instance name is generated if the instance is empty.
+func NewIDGenerator(instance string) (*IDGenerator, error) {
+	if instance == "" {
+		instance = RandString(instanceSize)
+	}
+
+	if strings.ContainsAny(instance, "- \t\r\n") {
+		return nil, fmt.Errorf("invalid instance name %q: contains a dash or whitespace", instance)
+	}
+
+	return &IDGenerator{Instance: instance}, nil
+}
+
+// Next returns a new unique ID.
+func (g *IDGenerator) Next() string {
+	seq := g.seq.Add(1)
+	return g.Instance + "-" + strconv.FormatUint(seq, 36) + "-" + RandString(nonceSize)
+}
+
+// ParseID returns the instance and sequence number of an ID created by an IDGenerator.
+func ParseID(id string) (instance string, seq uint64, ok bool) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 3 {
+		return "", 0, false
+	}
+
+	seq, err := strconv.ParseUint(parts[1], 36, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return parts[0], seq, true
+}
+
+// RandString returns a string encoding the given number of random bytes.
+// Only lower case letters and digits are used.
+func RandString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("read random bytes: %w", err))
+	}
+
+	return encoding.EncodeToString(b)
+}